@@ -1,14 +1,37 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 
 	furnish "github.com/tenderly/furnish/pkg"
+	"github.com/tenderly/furnish/pkg/facts"
+	"github.com/tenderly/furnish/pkg/graph"
 	"github.com/tenderly/furnish/pkg/log"
+	"github.com/tenderly/furnish/pkg/module"
+	"github.com/tenderly/furnish/pkg/module/modules/shell"
+	"github.com/tenderly/furnish/pkg/report"
+	"github.com/tenderly/furnish/pkg/state"
+	"github.com/tenderly/furnish/pkg/util"
+)
+
+const (
+	exitError             = 1
+	exitMissingDependency = 3
+	exitMandatoryFailed   = 4
+	exitDependencyFailed  = 5
+	exitDependencyCycle   = 6
+	exitInterrupted       = 130
 )
 
 func main() {
@@ -16,9 +39,67 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "furnish"
 
-	app.Commands = append(app.Commands, DebugPrintCmd(), RunCmd())
-	if err := app.Run(os.Args); err != nil {
+	app.Commands = append(app.Commands, DebugPrintCmd(), RunCmd(), PlanCmd(), ValidateCmd(), GraphCmd(), FactsCmd())
+
+	ctx, stop := interruptContext(context.Background())
+	err := app.RunContext(ctx, os.Args)
+	stop()
+	if err != nil {
 		log.Error("failed running app", "err", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// interruptContext returns a context which is cancelled on the first SIGINT or SIGTERM, so the run stops
+// after the running modules. The second signal kills the running commands and exits right away.
+func interruptContext(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig, ok := <-signals
+		if !ok {
+			return
+		}
+		color.Yellow("\n[interrupt] %s received, waiting for the running modules, press Ctrl-C again to exit", sig)
+		cancel()
+		if sig, ok = <-signals; ok {
+			color.Red("\n[interrupt] %s received again, exiting", sig)
+			shell.KillAll()
+			os.Exit(exitInterrupted)
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(signals)
+		cancel()
+	}
+}
+
+// exitCode maps the engine errors to distinct exit codes so scripts can tell the failures apart.
+func exitCode(err error) int {
+	var (
+		missingDependency *module.ErrMissingDependency
+		mandatoryFailed   *module.ErrMandatoryFailed
+		dependencyFailed  *module.ErrDependencyFailed
+		dependencyCycle   *module.ErrDependencyCycle
+		interrupted       *module.ErrInterrupted
+	)
+	switch {
+	case stderrors.As(err, &missingDependency):
+		return exitMissingDependency
+	case stderrors.As(err, &mandatoryFailed):
+		return exitMandatoryFailed
+	case stderrors.As(err, &dependencyFailed):
+		return exitDependencyFailed
+	case stderrors.As(err, &dependencyCycle):
+		return exitDependencyCycle
+	case stderrors.As(err, &interrupted):
+		return exitInterrupted
+	default:
+		return exitError
 	}
 }
 
@@ -26,6 +107,175 @@ func RunCmd() *cli.Command {
 	return &cli.Command{
 		Name:        "apply",
 		Description: "applies the modules",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "--config example.yaml",
+			},
+			profileFlag,
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Usage:   "--jobs 4, number of modules applied in parallel, overrides global.concurrency",
+			},
+			&cli.StringFlag{
+				Name:  "report",
+				Usage: "--report json|junit, writes a report of the run",
+			},
+			&cli.StringFlag{
+				Name:  "report-file",
+				Usage: "--report-file report.xml, defaults to stdout",
+			},
+			&cli.BoolFlag{
+				Name:  "yes",
+				Usage: "--yes, answers yes to every question which isn't in the answers file",
+			},
+			&cli.BoolFlag{
+				Name:  "no",
+				Usage: "--no, answers no to every question which isn't in the answers file",
+			},
+			&cli.StringFlag{
+				Name:  "answers",
+				Usage: "--answers answers.yaml, maps module IDs and install-<manager> to yes or no",
+			},
+			&cli.BoolFlag{
+				Name:  "resume",
+				Usage: "--resume, skips the modules which succeeded in the previous run unless their config changed",
+			},
+			&cli.StringFlag{
+				Name:  "state-file",
+				Usage: "--state-file state.json, defaults to ~/.local/state/furnish/state.json",
+			},
+			&cli.DurationFlag{
+				Name:  "grace",
+				Value: 30 * time.Second,
+				Usage: "--grace 1m, how long the running modules may take to finish after Ctrl-C before they are killed, " +
+					"modules reading from the terminal are interrupted right away",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			cfgPath := c.String("config")
+			if cfgPath == "" {
+				cfgPath = "furnish.yaml"
+			}
+
+			if err := setupPrompter(c); err != nil {
+				return err
+			}
+
+			reportFormat := report.Format(c.String("report"))
+			if reportFormat != "" {
+				if err := reportFormat.Validate(); err != nil {
+					return err
+				}
+			}
+			// A report written to stdout keeps it to itself, the progress and the output of the commands
+			// go to stderr until the report is written.
+			stdout := os.Stdout
+			if reportFormat != "" && c.String("report-file") == "" {
+				os.Stdout, color.Output = os.Stderr, os.Stderr
+			}
+
+			decl, err := loadDeclaration(c, cfgPath)
+			if err != nil {
+				return err
+			}
+
+			applyCfg := decl.ApplyConfig()
+			if c.IsSet("jobs") {
+				applyCfg.Concurrency = c.Int("jobs")
+			}
+			applyCfg.Grace = c.Duration("grace")
+
+			statePath := c.String("state-file")
+			if statePath == "" {
+				if statePath, err = state.DefaultPath(); err != nil {
+					return errors.Wrap(err, "state file")
+				}
+			}
+			applyCfg.Recorders = append(applyCfg.Recorders, state.NewStore(statePath))
+
+			journalPath, err := state.JournalPath(statePath, cfgPath)
+			if err != nil {
+				return errors.Wrap(err, "journal")
+			}
+			journal, err := state.NewJournal(journalPath, c.Bool("resume"))
+			if err != nil {
+				return errors.Wrap(err, "journal")
+			}
+			if c.Bool("resume") {
+				if !journal.Resuming() {
+					color.Yellow("[warn] no previous run to resume, applying everything")
+				}
+				applyCfg.Resumer = journal
+			}
+			applyCfg.Recorders = append(applyCfg.Recorders, journal)
+
+			color.White("\n\n")
+			runReport, err := decl.Stages().Apply(c.Context, applyCfg)
+			os.Stdout = stdout
+			if reportFormat != "" {
+				if reportErr := report.WriteFile(c.String("report-file"), reportFormat, runReport); reportErr != nil {
+					log.Error("failed writing report", "err", reportErr)
+				}
+			}
+			if err != nil {
+				var interrupted *module.ErrInterrupted
+				if stderrors.As(err, &interrupted) {
+					color.Yellow("[interrupt] the finished modules are recorded, run apply --resume to continue")
+				}
+				return errors.Wrap(err, "apply")
+			}
+
+			return nil
+		},
+	}
+}
+
+func PlanCmd() *cli.Command {
+	return &cli.Command{
+		Name:        "plan",
+		Description: "shows what apply would change without changing anything",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "--config example.yaml",
+			},
+			profileFlag,
+		},
+		Action: func(c *cli.Context) error {
+			cfgPath := c.String("config")
+			if cfgPath == "" {
+				cfgPath = "furnish.yaml"
+			}
+
+			// plan only looks at the machine, so it neither installs a missing manager nor asks for sudo.
+			decl, err := readDeclaration(c, cfgPath)
+			if err != nil {
+				return err
+			}
+			if err = decl.PreparePlan(); err != nil {
+				return errors.Wrap(err, "validate")
+			}
+
+			color.White("\n\n")
+			plan, err := decl.Stages().Plan(c.Context, decl.ApplyConfig())
+			if err != nil {
+				return errors.Wrap(err, "plan")
+			}
+			plan.Print()
+
+			return nil
+		},
+	}
+}
+
+func ValidateCmd() *cli.Command {
+	return &cli.Command{
+		Name:        "validate",
+		Description: "checks the configuration without touching the machine and reports every problem",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:    "config",
@@ -44,22 +294,162 @@ func RunCmd() *cli.Command {
 				return errors.Wrap(err, "reading config")
 			}
 
-			if err = decl.Validate(); err != nil {
-				return errors.Wrap(err, "validate")
+			problems := decl.Check()
+			for _, p := range problems {
+				color.Red(p.String())
+			}
+			if len(problems) > 0 {
+				return errors.Errorf("found %d problems in %s", len(problems), cfgPath)
+			}
+
+			color.Green("[✔] %s is valid", cfgPath)
+			return nil
+		},
+	}
+}
+
+func GraphCmd() *cli.Command {
+	return &cli.Command{
+		Name:        "graph",
+		Description: "prints the dependency graph of the stages and modules",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "--config example.yaml",
+			},
+			profileFlag,
+			&cli.StringFlag{
+				Name:  "format",
+				Value: string(graph.FormatDOT),
+				Usage: "--format dot|mermaid|json",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "--output graph.dot, defaults to stdout",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			cfgPath := c.String("config")
+			if cfgPath == "" {
+				cfgPath = "furnish.yaml"
+			}
+
+			format := graph.Format(c.String("format"))
+			if err := format.Validate(); err != nil {
+				return err
 			}
 
-			if err = decl.Initialize(); err != nil {
+			// The graph can be piped to a renderer, the progress goes to stderr.
+			color.Output = os.Stderr
+
+			decl, err := furnish.Load(cfgPath)
+			if err != nil {
+				return errors.Wrap(err, "reading config")
+			}
+
+			if err = decl.SelectProfiles(c.StringSlice("profile")...); err != nil {
+				return errors.Wrap(err, "profiles")
+			}
+
+			if err = decl.Phases.Initialize(); err != nil {
 				return errors.Wrap(err, "initialize")
 			}
 
-			color.White("\n\n")
-			decl.Stages().Apply(c.Context)
+			g, err := graph.Build(decl.Stages())
+			if err != nil {
+				return errors.Wrap(err, "graph")
+			}
+
+			return graph.WriteFile(c.String("output"), format, g)
+		},
+	}
+}
+
+func FactsCmd() *cli.Command {
+	return &cli.Command{
+		Name:        "facts",
+		Description: "prints the facts gathered about the machine as json",
+		Action: func(c *cli.Context) error {
+			machine, err := facts.Current()
+			if err != nil {
+				return errors.Wrap(err, "gathering facts")
+			}
+
+			out, err := json.MarshalIndent(machine, "", "  ")
+			if err != nil {
+				return errors.Wrap(err, "printing facts")
+			}
+			fmt.Println(string(out))
 
 			return nil
 		},
 	}
 }
 
+var profileFlag = &cli.StringSliceFlag{
+	Name:    "profile",
+	Aliases: []string{"p"},
+	Usage:   "--profile work, applies only what the profile selects, repeat to stack profiles",
+}
+
+// loadDeclaration loads the declaration, selects the profiles and prepares it for applying.
+func loadDeclaration(c *cli.Context, cfgPath string) (*furnish.Declaration, error) {
+	decl, err := readDeclaration(c, cfgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = decl.Validate(); err != nil {
+		return nil, errors.Wrap(err, "validate")
+	}
+
+	if err = decl.Initialize(); err != nil {
+		return nil, errors.Wrap(err, "initialize")
+	}
+	return decl, nil
+}
+
+// readDeclaration loads the declaration and selects the profiles.
+func readDeclaration(c *cli.Context, cfgPath string) (*furnish.Declaration, error) {
+	decl, err := furnish.Load(cfgPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading config")
+	}
+
+	if err = decl.SelectProfiles(c.StringSlice("profile")...); err != nil {
+		return nil, errors.Wrap(err, "profiles")
+	}
+	return decl, nil
+}
+
+// setupPrompter replaces the interactive prompter with the answers given on the command line.
+func setupPrompter(c *cli.Context) error {
+	if c.Bool("yes") && c.Bool("no") {
+		return errors.New("--yes and --no can't be used together")
+	}
+
+	var prompter util.Prompter = util.NewReaderPrompter(os.Stdin)
+	switch {
+	case c.Bool("yes"):
+		prompter = util.StaticPrompter(true)
+	case c.Bool("no"):
+		prompter = util.StaticPrompter(false)
+	}
+
+	if path := c.String("answers"); path != "" {
+		answers, err := util.LoadAnswers(path)
+		if err != nil {
+			return errors.Wrap(err, "reading answers")
+		}
+		prompter = util.NewAnswersPrompter(answers, prompter)
+	}
+
+	util.SetPrompter(prompter)
+	return nil
+}
+
 func DebugPrintCmd() *cli.Command {
 	return &cli.Command{
 		Name:        "debug",
@@ -70,11 +460,12 @@ func DebugPrintCmd() *cli.Command {
 				Aliases: []string{"c"},
 				Usage:   "--config example.yaml",
 			},
+			profileFlag,
 		},
 		Action: func(c *cli.Context) error {
 			cfgPath := c.String("config")
 			if cfgPath == "" {
-				cfgPath = "furnishl.yaml"
+				cfgPath = "furnish.yaml"
 			}
 
 			decl, err := furnish.Load(cfgPath)
@@ -82,14 +473,16 @@ func DebugPrintCmd() *cli.Command {
 				return errors.Wrap(err, "reading config")
 			}
 
-			log.Info("configuration", "cfg", decl)
+			if err = decl.SelectProfiles(c.StringSlice("profile")...); err != nil {
+				return errors.Wrap(err, "profiles")
+			}
 
-			color.White("\n\n")
-			_, err = decl.Stages().Apply(c.Context)
+			// The declaration is printed after the templates are rendered, as the modules will see it.
+			rendered, err := json.MarshalIndent(decl, "", "  ")
 			if err != nil {
-				return err
+				return errors.Wrap(err, "printing config")
 			}
-
+			fmt.Println(string(rendered))
 			return nil
 		},
 	}
@@ -0,0 +1,298 @@
+package condition
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Expression is a parsed when condition, e.g. os == "linux" && (arch == "amd64" || arch == "arm64").
+// Identifiers refer to facts, strings are quoted with double or single quotes. A bare identifier or
+// string is true when it isn't empty or false.
+type Expression struct {
+	text string
+	root node
+}
+
+func (e *Expression) String() string { return e.text }
+
+// Parse parses the expression without evaluating it, so invalid conditions fail before anything runs.
+func Parse(text string) (*Expression, error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "condition '%s'", text)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.or()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = errors.Errorf("unexpected '%s' at %d", p.peek().value, p.peek().pos)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "condition '%s'", text)
+	}
+	return &Expression{text: text, root: root}, nil
+}
+
+// Eval evaluates the expression against the facts. Unknown facts fail the evaluation.
+func (e *Expression) Eval(facts map[string]string) (bool, error) {
+	ok, err := e.root.eval(facts)
+	if err != nil {
+		return false, errors.Wrapf(err, "condition '%s'", e.text)
+	}
+	return ok, nil
+}
+
+// Evaluator evaluates the conditions against a fixed set of facts.
+type Evaluator struct {
+	facts map[string]string
+}
+
+func NewEvaluator(facts map[string]string) *Evaluator {
+	return &Evaluator{facts: facts}
+}
+
+func (ev *Evaluator) Evaluate(text string) (bool, error) {
+	expr, err := Parse(text)
+	if err != nil {
+		return false, err
+	}
+	return expr.Eval(ev.facts)
+}
+
+type node interface {
+	eval(facts map[string]string) (bool, error)
+}
+
+type operand struct {
+	ident bool
+	value string
+}
+
+func (o operand) resolve(facts map[string]string) (string, error) {
+	if !o.ident {
+		return o.value, nil
+	}
+	value, ok := facts[o.value]
+	if !ok {
+		known := make([]string, 0, len(facts))
+		for name := range facts {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return "", errors.Errorf("unknown fact '%s', known facts are %s", o.value, strings.Join(known, ", "))
+	}
+	return value, nil
+}
+
+type truthy struct{ operand operand }
+
+func (t truthy) eval(facts map[string]string) (bool, error) {
+	value, err := t.operand.resolve(facts)
+	if err != nil {
+		return false, err
+	}
+	return value != "" && value != "false", nil
+}
+
+type compare struct {
+	left, right operand
+	equal       bool
+}
+
+func (c compare) eval(facts map[string]string) (bool, error) {
+	left, err := c.left.resolve(facts)
+	if err != nil {
+		return false, err
+	}
+	right, err := c.right.resolve(facts)
+	if err != nil {
+		return false, err
+	}
+	return (left == right) == c.equal, nil
+}
+
+type not struct{ node node }
+
+func (n not) eval(facts map[string]string) (bool, error) {
+	ok, err := n.node.eval(facts)
+	return !ok, err
+}
+
+type logical struct {
+	left, right node
+	and         bool
+}
+
+func (l logical) eval(facts map[string]string) (bool, error) {
+	left, err := l.left.eval(facts)
+	if err != nil {
+		return false, err
+	}
+	if left != l.and {
+		// false && x, true || x
+		return left, nil
+	}
+	return l.right.eval(facts)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+var operators = []string{"==", "!=", "&&", "||", "!", "(", ")"}
+
+// lex splits the text into tokens, their positions are byte offsets into the text.
+func lex(text string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(text); {
+		c, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case c == utf8.RuneError && size == 1:
+			return nil, errors.Errorf("invalid UTF-8 at %d", i)
+		case unicode.IsSpace(c):
+			i += size
+		case c == '"' || c == '\'':
+			end := strings.IndexRune(text[i+1:], c)
+			if end < 0 {
+				return nil, errors.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, value: text[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case isIdent(c):
+			start := i
+			for i < len(text) {
+				c, size := utf8.DecodeRuneInString(text[i:])
+				if !isIdent(c) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, token{kind: tokenIdent, value: text[start:i], pos: start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(text[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, value: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.Errorf("unexpected '%c' at %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(text)}), nil
+}
+
+func isIdent(c rune) bool {
+	return c == '_' || c == '-' || c == '.' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right node
+		if right, err = p.and(); err == nil {
+			left = logical{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.unary()
+	for err == nil && p.accept("&&") {
+		var right node
+		if right, err = p.unary(); err == nil {
+			left = logical{left: left, right: right, and: true}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) unary() (node, error) {
+	if p.accept("!") {
+		n, err := p.unary()
+		return not{node: n}, err
+	}
+	if p.accept("(") {
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, errors.Errorf("expected ')' at %d", p.peek().pos)
+		}
+		return n, nil
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.accept("=="):
+		right, err := p.operand()
+		return compare{left: left, right: right, equal: true}, err
+	case p.accept("!="):
+		right, err := p.operand()
+		return compare{left: left, right: right}, err
+	}
+	return truthy{operand: left}, nil
+}
+
+func (p *parser) operand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenIdent:
+		if t.value == "true" || t.value == "false" {
+			return operand{value: t.value}, nil
+		}
+		return operand{ident: true, value: t.value}, nil
+	case tokenString:
+		return operand{value: t.value}, nil
+	case tokenEOF:
+		return operand{}, errors.New("unexpected end")
+	default:
+		return operand{}, errors.Errorf("unexpected '%s' at %d", t.value, t.pos)
+	}
+}
@@ -0,0 +1,140 @@
+package condition
+
+import (
+	"strings"
+	"testing"
+)
+
+var testFacts = map[string]string{
+	"os":       "linux",
+	"arch":     "arm64",
+	"hostname": "café.local",
+	"empty":    "",
+	"disabled": "false",
+	"enabled":  "true",
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{text: `os == "linux"`, want: true},
+		{text: `os == 'linux'`, want: true},
+		{text: `os != "linux"`, want: false},
+		{text: `"linux" == os`, want: true},
+		{text: `os == "linux && arch"`, want: false},
+		{text: `os == "linux" && arch == "amd64" || arch == "arm64"`, want: true},
+		{text: `os == "darwin" && arch == "amd64" || arch == "arm64"`, want: true},
+		{text: `os == "darwin" && (arch == "amd64" || arch == "arm64")`, want: false},
+		{text: `arch == "arm64" || os == "darwin" && arch == "amd64"`, want: true},
+		{text: `(arch == "arm64" || os == "darwin") && arch == "amd64"`, want: false},
+		{text: `!(os == "darwin")`, want: true},
+		{text: `!os == "darwin"`, want: true},
+		{text: `!!enabled`, want: true},
+		{text: `os!="darwin"`, want: true},
+		{text: `! os != "darwin"`, want: false},
+		{text: `enabled`, want: true},
+		{text: `disabled`, want: false},
+		{text: `empty`, want: false},
+		{text: `os`, want: true},
+		{text: `!empty && !disabled`, want: true},
+		{text: `true`, want: true},
+		{text: `false`, want: false},
+		{text: `""`, want: false},
+		{text: `"x"`, want: true},
+		{text: `hostname == "café.local"`, want: true},
+		{text: `"日本" != hostname`, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			expr, err := Parse(tt.text)
+			if err != nil {
+				t.Fatalf("parse: %s", err)
+			}
+			got, err := expr.Eval(testFacts)
+			if err != nil {
+				t.Fatalf("eval: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalUnknownFact(t *testing.T) {
+	tests := []struct {
+		text string
+		// fact is the unknown fact the error names, empty if the evaluation doesn't reach it.
+		fact string
+	}{
+		{text: `distro == "debian"`, fact: "distro"},
+		{text: `!distro`, fact: "distro"},
+		{text: `os == "linux" && distro == "debian"`, fact: "distro"},
+		{text: `os == "darwin" && distro == "debian"`},
+		{text: `os == "linux" || distro == "debian"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			expr, err := Parse(tt.text)
+			if err != nil {
+				t.Fatalf("parse: %s", err)
+			}
+			_, err = expr.Eval(testFacts)
+			if tt.fact == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "unknown fact '"+tt.fact+"'") {
+				t.Errorf("got error %v, want the unknown fact '%s'", err, tt.fact)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: ``, want: "unexpected end"},
+		{text: `os ==`, want: "unexpected end"},
+		{text: `os == "linux`, want: "unterminated string at 6"},
+		{text: `os == 'linux"`, want: "unterminated string at 6"},
+		{text: `(os == "linux"`, want: "expected ')' at 14"},
+		{text: `os == "linux")`, want: "unexpected ')' at 13"},
+		{text: `os = "linux"`, want: "unexpected '=' at 3"},
+		{text: `os == "linux" & arch`, want: "unexpected '&' at 14"},
+		{text: `os == == "linux"`, want: "unexpected '==' at 6"},
+		{text: `os "linux"`, want: "unexpected 'linux' at 3"},
+		{text: `é == "x" # y`, want: "unexpected '#' at 10"},
+		{text: "os == \xff", want: "invalid UTF-8 at 6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			_, err := Parse(tt.text)
+			if err == nil {
+				t.Fatal("parse succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluator(t *testing.T) {
+	ev := NewEvaluator(testFacts)
+	if ok, err := ev.Evaluate(`os == "linux"`); err != nil || !ok {
+		t.Errorf("got %v, %v, want true", ok, err)
+	}
+	if _, err := ev.Evaluate(`os ==`); err == nil {
+		t.Error("evaluating an invalid condition succeeded")
+	}
+}
@@ -1,18 +1,22 @@
 package furnish
 
 import (
-	"os"
-
-	"github.com/tenderly/furnish/pkg/module/modules/shell"
-	"github.com/tenderly/furnish/pkg/module/modules/ssh"
-	"github.com/tenderly/furnish/pkg/module/modules/xcode"
+	"fmt"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 
+	"github.com/tenderly/furnish/pkg/condition"
+	"github.com/tenderly/furnish/pkg/facts"
 	"github.com/tenderly/furnish/pkg/module"
+	"github.com/tenderly/furnish/pkg/module/modules/shell"
 	"github.com/tenderly/furnish/pkg/pkgmanager"
+
+	// The built-in module kinds register themselves in the module registry.
+	_ "github.com/tenderly/furnish/pkg/module/modules/ssh"
+	_ "github.com/tenderly/furnish/pkg/module/modules/xcode"
 )
 
 var _ module.Stage = (*Stage)(nil)
@@ -20,28 +24,80 @@ var _ module.Stage = (*Stage)(nil)
 type Stage struct {
 	module.BaseDependable `yaml:",inline"`
 
-	// Unknown module.Arbitrary `yaml:",inline" json:"modules"`
-	XCodeSelect *xcode.XCodeSelect  `yaml:"xcode-select" json:"xcode-select"`
-	SSH         *ssh.SSH            `yaml:"ssh" json:"ssh"`
-	Packages    pkgmanager.Packages `yaml:"packages"     json:"packages"`
-	Shell       shell.Shell         `yaml:"shell"        json:"shell"`
+	// Declared contains the modules of every kind in the order they are declared.
+	Declared module.Modules `yaml:"-" json:"modules"`
+
+	// problems found while decoding which don't stop the stage from being applied, e.g. unknown keys.
+	problems Problems
 }
 
-func (s *Stage) Modules() module.Modules {
-	modules := make(module.Modules, 0)
-	if s.XCodeSelect != nil && s.XCodeSelect.Enabled {
-		modules = append(modules, s.XCodeSelect)
+// UnmarshalYAML decodes the stage fields and passes every other key to the decoder of the module kind
+// registered under it. Unknown kinds are recorded as problems, so validate reports the rest as well,
+// but they fail Validate.
+func (s *Stage) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return errors.Errorf("line %d: stage must be a mapping", node.Line)
+	}
+	if err := node.Decode(&s.BaseDependable); err != nil {
+		return err
+	}
+	s.Source = module.Source{Line: node.Line}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if module.IsBaseKey(key.Value) {
+			continue
+		}
+
+		kind := module.Kind(key.Value)
+		decode, ok := module.Lookup(kind)
+		if !ok {
+			s.problems = append(s.problems, Problem{
+				Source:  module.Source{Line: key.Line},
+				Message: fmt.Sprintf("unknown module kind '%s', known kinds are %v", kind, module.Kinds()),
+				fatal:   true,
+			})
+			continue
+		}
+		modules, err := decode(value)
+		if err != nil {
+			return errors.Wrapf(err, "line %d: decoding '%s'", key.Line, kind)
+		}
+		for _, m := range modules {
+			m.SetKind(kind)
+			if m.GetSource().Line == 0 {
+				m.SetSource(module.Source{Line: value.Line})
+			}
+		}
+		s.checkKeys(kind, value, modules)
+		s.Declared = append(s.Declared, modules...)
 	}
-	if s.SSH != nil && s.SSH.Enabled {
-		modules = append(modules, s.SSH)
+	return nil
+}
+
+// checkKeys records the keys of the module declarations which none of the module fields use.
+// A list is matched to the modules by position, a mapping declares a single module.
+func (s *Stage) checkKeys(kind module.Kind, node *yaml.Node, modules module.Modules) {
+	items := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		items = node.Content
 	}
-	for _, s := range s.Shell {
-		modules = append(modules, s)
+	if len(items) != len(modules) {
+		return
 	}
-	for _, p := range s.Packages {
-		modules = append(modules, p)
+	for i, item := range items {
+		for _, key := range module.UnknownKeys(item, modules[i]) {
+			s.problems = append(s.problems, Problem{
+				Source:  module.Source{Line: key.Line},
+				Message: fmt.Sprintf("unknown key '%s' in %s module '%s'", key.Value, kind, modules[i].GetID()),
+			})
+		}
 	}
-	return modules
+}
+
+func (s *Stage) Modules() module.Modules {
+	modules := make(module.Modules, 0, len(s.Declared))
+	return append(modules, s.Declared...)
 }
 
 func (s *Stage) Initialize() error {
@@ -89,21 +145,123 @@ func (ss Stages) Initialize() error {
 
 type Global struct {
 	PackageManagers pkgmanager.MultiManagerConfig `yaml:"package-managers" json:"package_managers"`
+	// Concurrency is passed to module.ApplyConfig, the --jobs flag of apply overrides it.
+	Concurrency int `yaml:"concurrency" json:"concurrency"`
+	// HostOverlays is the directory, relative to the config, with the <hostname>.yaml overlays. Defaults to hosts.
+	HostOverlays string `yaml:"host-overlays" json:"host_overlays,omitempty"`
+	// Shell runs the shell executions and the package manager commands, a path or a name in PATH.
+	// Defaults to bash.
+	Shell string `yaml:"shell" json:"shell,omitempty"`
+	// Retries, RetryDelay and Timeout are the defaults of the stages and modules which don't set their own.
+	Retries    int           `yaml:"retries"     json:"retries,omitempty"`
+	RetryDelay time.Duration `yaml:"retry-delay" json:"retry_delay,omitempty"`
+	Timeout    time.Duration `yaml:"timeout"     json:"timeout,omitempty"`
 }
 
-func (g *Global) Validate() error { return g.PackageManagers.Validate() }
+// Validate checks the global config. The shell is set first, installing a package manager already runs it.
+func (g *Global) Validate() error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+	return g.PackageManagers.Validate()
+}
+
+// Check is Validate without installing a missing package manager.
+func (g *Global) Check() error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+	return g.PackageManagers.Check()
+}
+
+func (g *Global) validate() error {
+	if g.Shell != "" {
+		if err := shell.SetShell(g.Shell); err != nil {
+			return errors.Wrap(err, "global")
+		}
+	}
+	if g.Retries < 0 || g.RetryDelay < 0 || g.Timeout < 0 {
+		return errors.New("global: retries, retry-delay and timeout can't be negative")
+	}
+	if len(g.PackageManagers) == 0 {
+		if detected, err := pkgmanager.Detect(); err == nil {
+			color.HiBlue("[init] no package manager declared, using %s found on the machine", detected.Name)
+			g.PackageManagers = append(g.PackageManagers, detected)
+		}
+	}
+	return nil
+}
 
 func (g *Global) Initialize() error { return g.PackageManagers.Initialize() }
 
 type Declaration struct {
 	FileVersion module.Version `yaml:"version" json:"file_version"`
 	Global      Global         `yaml:"global"  json:"global"`
-	Phases      Stages         `yaml:",inline" json:"stages"`
+	// Include lists the files and globs merged into the declaration, relative to the declaring file.
+	Include []string `yaml:"include" json:"include,omitempty"`
+	// Vars are available in the templates of the stages and modules as {{ .Vars.name }}.
+	Vars map[string]string `yaml:"vars" json:"vars,omitempty"`
+	// Profiles select subsets of the stages, UseProfiles are selected when no profile is passed.
+	Profiles    Profiles `yaml:"profiles"     json:"profiles,omitempty"`
+	UseProfiles []string `yaml:"use-profiles" json:"use_profiles,omitempty"`
+	Phases      Stages   `yaml:",inline"      json:"stages"`
+
+	// facts are gathered while loading, the templates and the when conditions refer to them.
+	facts *facts.Facts
+	// problems found while loading which don't stop the declaration from being applied.
+	problems Problems
 }
 
 func (d *Declaration) Modules() module.Modules { return d.Phases.Modules() }
 
-func (d *Declaration) Validate() error { return d.Global.Validate() }
+// Validate checks the package managers, the conditions and the modules, so a broken declaration
+// fails before anything is applied.
+func (d *Declaration) Validate() error {
+	if err := d.Global.Validate(); err != nil {
+		return err
+	}
+	return d.validateStages()
+}
+
+// PreparePlan validates and initializes the declaration for plan. Unlike Validate and Initialize it
+// doesn't install a missing package manager or ask for the sudo credentials.
+func (d *Declaration) PreparePlan() error {
+	if err := d.Global.Check(); err != nil {
+		return err
+	}
+	if err := d.validateStages(); err != nil {
+		return err
+	}
+	if err := d.Global.PackageManagers.InitializeChecked(); err != nil {
+		return errors.Wrap(err, "initializing global")
+	}
+	if err := d.Phases.Initialize(); err != nil {
+		return errors.Wrap(err, "initializing stages")
+	}
+	return nil
+}
+
+func (d *Declaration) validateStages() error {
+	for id, s := range d.Phases {
+		if s == nil {
+			continue
+		}
+		for _, p := range s.problems {
+			if p.fatal {
+				return errors.Errorf("stage '%s': %s", id, p)
+			}
+		}
+		if problems := checkDependable(s, fmt.Sprintf("stage '%s'", id)); len(problems) > 0 {
+			return errors.New(problems[0].String())
+		}
+		for _, m := range s.Declared {
+			if problems := checkDependable(m, fmt.Sprintf("module '%s'", m.GetID())); len(problems) > 0 {
+				return errors.New(problems[0].String())
+			}
+		}
+	}
+	return nil
+}
 
 func (d *Declaration) Initialize() error {
 	if err := d.Global.Initialize(); err != nil {
@@ -117,16 +275,18 @@ func (d *Declaration) Initialize() error {
 
 func (d *Declaration) Stages() module.Stages { return d.Phases.Stages() }
 
-func Load(path string) (*Declaration, error) {
-	file, err := os.ReadFile(path)
-	if err != nil {
-		return nil, errors.Wrap(err, "config file read")
+func (d *Declaration) ApplyConfig() *module.ApplyConfig {
+	cfg := &module.ApplyConfig{
+		Concurrency: d.Global.Concurrency,
+		Retries:     d.Global.Retries,
+		RetryDelay:  d.Global.RetryDelay,
+		Timeout:     d.Global.Timeout,
 	}
-
-	decl := &Declaration{}
-	if err := yaml.Unmarshal(file, decl); err != nil {
-		return nil, errors.Wrap(err, "unmarshal config")
+	if m, err := pkgmanager.Default(); err == nil {
+		cfg.Manager = string(m.Name())
 	}
-
-	return decl, nil
+	if d.facts != nil {
+		cfg.Conditions = condition.NewEvaluator(d.facts.Map())
+	}
+	return cfg
 }
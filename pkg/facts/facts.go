@@ -0,0 +1,122 @@
+package facts
+
+import (
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Facts describe the machine furnish runs on. The templates refer to them as {{ .Facts.Distro }},
+// the when conditions by the names in Map.
+type Facts struct {
+	OS            string `json:"os"`
+	Distro        string `json:"distro"`
+	DistroVersion string `json:"distro_version"`
+	Kernel        string `json:"kernel"`
+	// Arch is the architecture of the machine, arm64 on Apple silicon even when furnish runs under Rosetta.
+	Arch string `json:"arch"`
+	// Translated is set when furnish runs under Rosetta.
+	Translated bool   `json:"translated"`
+	CPU        CPU    `json:"cpu"`
+	Memory     uint64 `json:"memory_bytes"`
+	Hostname   string `json:"hostname"`
+	User       string `json:"user"`
+	Home       string `json:"home"`
+	Shell      string `json:"shell"`
+	// PackageManagers are the known package managers found on the machine.
+	PackageManagers []string `json:"package_managers"`
+}
+
+type CPU struct {
+	Vendor string `json:"vendor"`
+	Model  string `json:"model"`
+	Cores  int    `json:"cores"`
+}
+
+// Gather collects the facts of the current machine. The facts which can't be found are left empty,
+// only a missing hostname or home directory fails.
+func Gather() (*Facts, error) {
+	f := &Facts{OS: runtime.GOOS, Shell: os.Getenv("SHELL")}
+	f.Distro, f.DistroVersion = distro()
+	f.Kernel = kernel()
+	f.Arch, f.Translated = arch()
+	f.CPU = cpu()
+	f.Memory = memory()
+	f.PackageManagers = packageManagers()
+
+	var err error
+	if f.Hostname, err = os.Hostname(); err != nil {
+		return nil, errors.Wrap(err, "hostname")
+	}
+	if f.Home, err = os.UserHomeDir(); err != nil {
+		return nil, errors.Wrap(err, "home directory")
+	}
+
+	// The user database isn't always available, e.g. in minimal containers, the environment is good enough.
+	if u, err := user.Current(); err == nil {
+		f.User = u.Username
+	} else {
+		f.User = os.Getenv("USER")
+	}
+	return f, nil
+}
+
+var (
+	currentMu sync.Mutex
+	current   *Facts
+)
+
+// Current returns the facts of the current machine, they are gathered once. A failed gathering
+// isn't kept, the next call tries again.
+func Current() (*Facts, error) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	if current != nil {
+		return current, nil
+	}
+	f, err := Gather()
+	if err != nil {
+		return nil, err
+	}
+	current = f
+	return current, nil
+}
+
+// HasPackageManager reports whether the package manager was found on the machine.
+func (f *Facts) HasPackageManager(name string) bool {
+	for _, m := range f.PackageManagers {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Map returns the facts by the names the when conditions refer to them. Every known package manager
+// is available as manager.<name>, true if it's found on the machine.
+func (f *Facts) Map() map[string]string {
+	facts := map[string]string{
+		"os":             f.OS,
+		"distro":         f.Distro,
+		"distro_version": f.DistroVersion,
+		"kernel":         f.Kernel,
+		"arch":           f.Arch,
+		"translated":     strconv.FormatBool(f.Translated),
+		"cpu.vendor":     f.CPU.Vendor,
+		"cpu.model":      f.CPU.Model,
+		"cpu.cores":      strconv.Itoa(f.CPU.Cores),
+		"memory_mb":      strconv.FormatUint(f.Memory/(1<<20), 10),
+		"hostname":       f.Hostname,
+		"user":           f.User,
+		"home":           f.Home,
+		"shell":          f.Shell,
+	}
+	for _, m := range knownPackageManagers {
+		facts["manager."+m.name] = strconv.FormatBool(f.HasPackageManager(m.name))
+	}
+	return facts
+}
@@ -0,0 +1,142 @@
+package facts
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/cpuid/v2"
+)
+
+// knownPackageManagers are looked up in PATH and in their default install locations,
+// brew isn't always in PATH before its shell setup is done.
+var knownPackageManagers = []struct {
+	name  string
+	paths []string
+}{
+	{name: "brew", paths: []string{"brew", "/opt/homebrew/bin/brew", "/usr/local/bin/brew", "/home/linuxbrew/.linuxbrew/bin/brew"}},
+	{name: "apt", paths: []string{"apt-get"}},
+	{name: "dnf", paths: []string{"dnf"}},
+	{name: "yum", paths: []string{"yum"}},
+	{name: "pacman", paths: []string{"pacman"}},
+	{name: "apk", paths: []string{"apk"}},
+	{name: "zypper", paths: []string{"zypper"}},
+	{name: "port", paths: []string{"port"}},
+	{name: "nix", paths: []string{"nix-env"}},
+}
+
+func packageManagers() []string {
+	found := make([]string, 0)
+	for _, m := range knownPackageManagers {
+		for _, path := range m.paths {
+			if _, err := exec.LookPath(path); err == nil {
+				found = append(found, m.name)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// output runs the command and returns its trimmed output, empty if it fails.
+func output(name string, args ...string) string {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// distro returns the distribution ID and version from os-release, macos and its version on darwin.
+func distro() (string, string) {
+	if runtime.GOOS == "darwin" {
+		return "macos", output("sw_vers", "-productVersion")
+	}
+
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+
+		var id, version string
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(scanner.Text(), "=")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(value, `"'`)
+			switch key {
+			case "ID":
+				id = value
+			case "VERSION_ID":
+				version = value
+			}
+		}
+		return id, version
+	}
+	return "", ""
+}
+
+func kernel() string {
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		return strings.TrimSpace(string(release))
+	}
+	return output("uname", "-r")
+}
+
+// arch returns the architecture of the machine in GOARCH terms. A binary built for amd64 runs under
+// Rosetta on Apple silicon, where uname reports x86_64 as well, so darwin is asked whether it's translated.
+func arch() (string, bool) {
+	if runtime.GOOS == "darwin" && output("sysctl", "-n", "sysctl.proc_translated") == "1" {
+		return "arm64", true
+	}
+
+	switch machine := output("uname", "-m"); machine {
+	case "x86_64", "amd64":
+		return "amd64", false
+	case "aarch64", "arm64":
+		return "arm64", false
+	case "":
+		return runtime.GOARCH, false
+	default:
+		return machine, false
+	}
+}
+
+func cpu() CPU {
+	c := CPU{Vendor: cpuid.CPU.VendorString, Model: cpuid.CPU.BrandName, Cores: runtime.NumCPU()}
+	if runtime.GOOS == "darwin" && c.Model == "" {
+		c.Model = output("sysctl", "-n", "machdep.cpu.brand_string")
+	}
+	return c
+}
+
+// memory returns the total memory in bytes.
+func memory() uint64 {
+	if runtime.GOOS == "darwin" {
+		bytes, _ := strconv.ParseUint(output("sysctl", "-n", "hw.memsize"), 10, 64)
+		return bytes
+	}
+
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
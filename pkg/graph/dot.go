@@ -0,0 +1,76 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/tenderly/furnish/pkg/module"
+)
+
+// dotShapes tell the module kinds apart, other kinds are boxes.
+var dotShapes = map[module.Kind]string{
+	"packages":     "component",
+	"shell":        "box",
+	"ssh":          "hexagon",
+	"xcode-select": "house",
+}
+
+// writeDOT draws every stage as a cluster. Arrows point from a dependency to its dependants, in the order
+// things are applied. Stage dependencies connect the invisible anchors of the clusters, dependencies
+// between modules of different stages are drawn outside of the clusters.
+func writeDOT(w io.Writer, g *Graph) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "digraph furnish {")
+	fmt.Fprintln(b, "  rankdir=LR;")
+	fmt.Fprintln(b, "  compound=true;")
+	fmt.Fprintln(b, `  node [fontname="Helvetica"];`)
+
+	crossEdges := make([]edge, 0)
+	for _, s := range g.Stages {
+		fmt.Fprintf(b, "\n  subgraph %s {\n", strconv.Quote("cluster_"+s.ID.String()))
+		fmt.Fprintf(b, "    label=%s;\n", strconv.Quote(s.ID.String()))
+		fmt.Fprintf(b, "    %s [shape=point, style=invis];\n", dotStageAnchor(s.ID))
+		for _, n := range s.Modules {
+			shape, ok := dotShapes[n.Kind]
+			if !ok {
+				shape = "box"
+			}
+			label := n.ID.String() + "\n" + n.Kind.String()
+			attrs := fmt.Sprintf("label=%s, shape=%s", strconv.Quote(label), shape)
+			switch n.status() {
+			case "mandatory":
+				attrs += ", penwidth=2"
+			case "optional":
+				attrs += ", style=dashed"
+			}
+			fmt.Fprintf(b, "    %s [%s];\n", dotNode(s.ID, n.ID), attrs)
+		}
+		local, cross := s.edges()
+		for _, e := range local {
+			fmt.Fprintf(b, "    %s -> %s;\n", dotNode(e.fromStage, e.from), dotNode(e.toStage, e.to))
+		}
+		crossEdges = append(crossEdges, cross...)
+		fmt.Fprintln(b, "  }")
+	}
+
+	fmt.Fprintln(b)
+	for _, e := range crossEdges {
+		fmt.Fprintf(b, "  %s -> %s;\n", dotNode(e.fromStage, e.from), dotNode(e.toStage, e.to))
+	}
+	for _, s := range g.Stages {
+		for _, d := range s.Dependencies {
+			fmt.Fprintf(b, "  %s -> %s [ltail=%s, lhead=%s];\n",
+				dotStageAnchor(d), dotStageAnchor(s.ID),
+				strconv.Quote("cluster_"+d.String()), strconv.Quote("cluster_"+s.ID.String()),
+			)
+		}
+	}
+	fmt.Fprintln(b, "}")
+	return b.Flush()
+}
+
+func dotNode(stage, id module.ID) string { return strconv.Quote(module.Qualify(stage, id).String()) }
+
+func dotStageAnchor(stage module.ID) string { return strconv.Quote(stage.String()) }
@@ -0,0 +1,161 @@
+package graph
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/tenderly/furnish/pkg/module"
+)
+
+type Format string
+
+const (
+	FormatDOT     Format = "dot"
+	FormatMermaid Format = "mermaid"
+	FormatJSON    Format = "json"
+)
+
+func (f Format) Validate() error {
+	switch f {
+	case FormatDOT, FormatMermaid, FormatJSON:
+		return nil
+	default:
+		return errors.Errorf("unsupported graph format '%s', use %s, %s or %s", f, FormatDOT, FormatMermaid, FormatJSON)
+	}
+}
+
+// Graph is the dependency graph of the stages and of the modules inside every stage,
+// in the order apply runs them.
+type Graph struct {
+	Stages []*Stage `json:"stages"`
+}
+
+type Stage struct {
+	ID           module.ID  `json:"id"`
+	Description  string     `json:"description,omitempty"`
+	Dependencies module.IDs `json:"dependencies"`
+	Modules      []*Node    `json:"modules"`
+}
+
+type Node struct {
+	ID           module.ID   `json:"id"`
+	Stage        module.ID   `json:"stage"`
+	Kind         module.Kind `json:"kind"`
+	Description  string      `json:"description,omitempty"`
+	Optional     bool        `json:"optional"`
+	Mandatory    bool        `json:"mandatory"`
+	Dependencies module.IDs  `json:"dependencies"`
+}
+
+// Build sorts the stages and their modules the same way apply does and returns their graph.
+func Build(stages module.Stages) (*Graph, error) {
+	sorted, modules, err := stages.Sorted()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Graph{Stages: make([]*Stage, 0, len(sorted))}
+	for _, s := range sorted {
+		stage := &Stage{
+			ID:           s.GetID(),
+			Description:  s.GetDescription(),
+			Dependencies: nonNil(s.GetDependencies()),
+			Modules:      make([]*Node, 0, len(modules[s.GetID()])),
+		}
+		for _, m := range modules[s.GetID()] {
+			stage.Modules = append(stage.Modules, &Node{
+				ID:           m.GetID(),
+				Stage:        s.GetID(),
+				Kind:         m.GetKind(),
+				Description:  m.GetDescription(),
+				Optional:     m.IsOptional(),
+				Mandatory:    m.IsMandatory(),
+				Dependencies: nonNil(m.GetDependencies()),
+			})
+		}
+		g.Stages = append(g.Stages, stage)
+	}
+	return g, nil
+}
+
+func nonNil(ids module.IDs) module.IDs {
+	if ids == nil {
+		return module.IDs{}
+	}
+	return ids
+}
+
+// Write encodes the graph in the format.
+func Write(w io.Writer, format Format, g *Graph) error {
+	switch format {
+	case FormatDOT:
+		return writeDOT(w, g)
+	case FormatMermaid:
+		return writeMermaid(w, g)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(g)
+	default:
+		return format.Validate()
+	}
+}
+
+// WriteFile writes the graph to the path, or to stdout if the path is empty.
+func WriteFile(path string, format Format, g *Graph) error {
+	if path == "" {
+		return Write(os.Stdout, format, g)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "create graph file")
+	}
+	if err := Write(file, format, g); err != nil {
+		file.Close()
+		return errors.Wrap(err, "write graph")
+	}
+	return file.Close()
+}
+
+// status describes whether the module has to, may or may fail to be applied.
+func (n *Node) status() string {
+	switch {
+	case n.Mandatory:
+		return "mandatory"
+	case n.Optional:
+		return "optional"
+	default:
+		return ""
+	}
+}
+
+// edge points from a dependency to its dependant.
+type edge struct {
+	fromStage, from module.ID
+	toStage, to     module.ID
+}
+
+// edges returns the module dependencies inside the stage and the ones on modules of other stages,
+// which are declared with qualified IDs.
+func (s *Stage) edges() (local, cross []edge) {
+	ids := make(map[module.ID]struct{}, len(s.Modules))
+	for _, n := range s.Modules {
+		ids[n.ID] = struct{}{}
+	}
+	for _, n := range s.Modules {
+		for _, d := range n.Dependencies {
+			if _, ok := ids[d]; ok {
+				local = append(local, edge{fromStage: s.ID, from: d, toStage: s.ID, to: n.ID})
+				continue
+			}
+			if stage, id, ok := d.Split(); ok {
+				cross = append(cross, edge{fromStage: stage, from: id, toStage: s.ID, to: n.ID})
+			}
+		}
+	}
+	return local, cross
+}
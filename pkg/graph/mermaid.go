@@ -0,0 +1,80 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tenderly/furnish/pkg/module"
+)
+
+// mermaidShapes tell the module kinds apart, other kinds are rectangles.
+var mermaidShapes = map[module.Kind][2]string{
+	"packages":     {"[(", ")]"},
+	"shell":        {"[", "]"},
+	"ssh":          {"{{", "}}"},
+	"xcode-select": {"([", "])"},
+}
+
+// writeMermaid draws every stage as a subgraph, arrows point from a dependency to its dependants.
+func writeMermaid(w io.Writer, g *Graph) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "flowchart LR")
+	fmt.Fprintln(b, "  classDef optional stroke-dasharray: 5 5;")
+	fmt.Fprintln(b, "  classDef mandatory stroke-width: 3px;")
+
+	ids := make(mermaidIDs)
+	crossEdges := make([]edge, 0)
+	for _, s := range g.Stages {
+		fmt.Fprintf(b, "\n  subgraph %s[%s]\n", ids.of(s.ID, ""), mermaidLabel(s.ID.String()))
+		for _, n := range s.Modules {
+			shape, ok := mermaidShapes[n.Kind]
+			if !ok {
+				shape = [2]string{"[", "]"}
+			}
+			label := mermaidLabel(n.ID.String() + "<br/><small>" + n.Kind.String() + "</small>")
+			fmt.Fprintf(b, "    %s%s%s%s", ids.of(s.ID, n.ID), shape[0], label, shape[1])
+			if status := n.status(); status != "" {
+				fmt.Fprintf(b, ":::%s", status)
+			}
+			fmt.Fprintln(b)
+		}
+		local, cross := s.edges()
+		for _, e := range local {
+			fmt.Fprintf(b, "    %s --> %s\n", ids.of(e.fromStage, e.from), ids.of(e.toStage, e.to))
+		}
+		crossEdges = append(crossEdges, cross...)
+		fmt.Fprintln(b, "  end")
+	}
+
+	fmt.Fprintln(b)
+	for _, e := range crossEdges {
+		fmt.Fprintf(b, "  %s --> %s\n", ids.of(e.fromStage, e.from), ids.of(e.toStage, e.to))
+	}
+	for _, s := range g.Stages {
+		for _, d := range s.Dependencies {
+			fmt.Fprintf(b, "  %s --> %s\n", ids.of(d, ""), ids.of(s.ID, ""))
+		}
+	}
+	return b.Flush()
+}
+
+// mermaidIDs numbers the stages and modules in the order they're drawn, n0, n1 and so on. The IDs may
+// contain any character mermaid doesn't accept in an identifier, the labels show them as they are.
+type mermaidIDs map[[2]module.ID]string
+
+// of returns the identifier of the module in the stage, or of the stage itself if the module is empty.
+func (ids mermaidIDs) of(stage, id module.ID) string {
+	key := [2]module.ID{stage, id}
+	if mermaidID, ok := ids[key]; ok {
+		return mermaidID
+	}
+	mermaidID := fmt.Sprintf("n%d", len(ids))
+	ids[key] = mermaidID
+	return mermaidID
+}
+
+func mermaidLabel(label string) string {
+	return `"` + strings.ReplaceAll(label, `"`, "#quot;") + `"`
+}
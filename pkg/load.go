@@ -0,0 +1,483 @@
+package furnish
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/tenderly/furnish/pkg/facts"
+	"github.com/tenderly/furnish/pkg/module"
+	"github.com/tenderly/furnish/pkg/pkgmanager"
+	"github.com/tenderly/furnish/pkg/render"
+)
+
+const defaultHostOverlays = "hosts"
+
+// Load reads the declaration and merges all the files it includes. The overlay of the current host,
+// hosts/<hostname>.yaml next to the config, is applied on top. The templates are rendered last,
+// so vars of every file are available everywhere.
+func Load(path string) (*Declaration, error) {
+	l := &loader{loading: make(map[string]struct{}), loaded: make(map[string]struct{})}
+	decl, err := l.load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	overlayPath, err := hostOverlayPath(filepath.Dir(path), decl.Global.HostOverlays)
+	if err != nil {
+		return nil, err
+	}
+	if overlayPath != "" {
+		overlay, err := l.load(overlayPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "host overlay")
+		}
+		decl.overlay(overlay)
+		color.HiBlue("[init] applied host overlay %s", overlayPath)
+	}
+
+	machine, err := facts.Current()
+	if err != nil {
+		return nil, errors.Wrap(err, "gathering facts")
+	}
+	decl.facts = machine
+	if err := decl.render(machine); err != nil {
+		return nil, errors.Wrap(err, "rendering config")
+	}
+	decl.resolvePaths()
+
+	return decl, nil
+}
+
+// hostOverlayPath returns the overlay file of the host, looked up by the full and the short hostname.
+// It's empty if the host doesn't have an overlay.
+func hostOverlayPath(dir, overlays string) (string, error) {
+	if overlays == "" {
+		overlays = defaultHostOverlays
+	}
+	if !filepath.IsAbs(overlays) {
+		overlays = filepath.Join(dir, overlays)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "hostname")
+	}
+	short, _, _ := strings.Cut(hostname, ".")
+	for _, name := range []string{hostname, short} {
+		path := filepath.Join(overlays, name+".yaml")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+type loader struct {
+	// loading contains the files which are being loaded, to catch include cycles.
+	loading map[string]struct{}
+	// loaded contains the files which are already loaded, a file included twice is merged once.
+	loaded map[string]struct{}
+}
+
+func (l *loader) load(path string) (*Declaration, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "config file path")
+	}
+	if _, ok := l.loading[abs]; ok {
+		return nil, errors.Errorf("include cycle, '%s' includes itself", path)
+	}
+	l.loading[abs] = struct{}{}
+	l.loaded[abs] = struct{}{}
+	defer delete(l.loading, abs)
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "config file read")
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(file, &root); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal config %s", path)
+	}
+	decl := &Declaration{}
+	if err := root.Decode(decl); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal config %s", path)
+	}
+	decl.checkKeys(path, &root)
+	decl.setSource(path)
+
+	for _, pattern := range decl.Include {
+		paths, err := includePaths(filepath.Dir(abs), pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "include in %s", path)
+		}
+		for _, p := range paths {
+			if l.isLoaded(p) {
+				continue
+			}
+			included, err := l.load(p)
+			if err != nil {
+				return nil, err
+			}
+			if err := decl.merge(included); err != nil {
+				return nil, errors.Wrapf(err, "include %s", p)
+			}
+		}
+	}
+
+	return decl, nil
+}
+
+// isLoaded reports whether the file is loaded and not being loaded, which is an include cycle.
+func (l *loader) isLoaded(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	_, loading := l.loading[abs]
+	_, loaded := l.loaded[abs]
+	return loaded && !loading
+}
+
+// includePaths resolves the pattern relative to the directory of the including file.
+// A pattern without glob characters must match an existing file.
+func includePaths(dir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern '%s'", pattern)
+	}
+	if len(paths) == 0 && !hasGlob(pattern) {
+		return nil, errors.Errorf("included file '%s' not found", pattern)
+	}
+	return paths, nil
+}
+
+func hasGlob(pattern string) bool {
+	for _, c := range pattern {
+		switch c {
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// setSource records the declaring file on the stages, modules and their problems.
+func (d *Declaration) setSource(path string) {
+	for id, s := range d.Phases {
+		if s == nil {
+			continue
+		}
+		s.ID = module.ID(id)
+		s.Source.File = path
+		for _, m := range s.Declared {
+			m.SetSource(module.Source{File: path, Line: m.GetSource().Line})
+		}
+		for i := range s.problems {
+			s.problems[i].Source.File = path
+		}
+	}
+}
+
+// resolvePaths resolves the relative paths of the modules against the directory of the declaring file.
+func (d *Declaration) resolvePaths() {
+	for _, m := range d.Modules() {
+		resolver, ok := m.(module.PathResolver)
+		if !ok {
+			continue
+		}
+		dir := "."
+		if file := m.GetSource().File; file != "" {
+			if abs, err := filepath.Abs(file); err == nil {
+				dir = filepath.Dir(abs)
+			}
+		}
+		resolver.ResolvePaths(dir)
+	}
+}
+
+// render executes the templates in the stages and modules, except the raw ones. The vars can refer to
+// the environment and the facts, but not to other vars.
+func (d *Declaration) render(machine *facts.Facts) error {
+	vars := make(map[string]string, len(d.Vars))
+	base := render.NewData(nil, machine)
+	for name, value := range d.Vars {
+		rendered, err := render.String(value, base)
+		if err != nil {
+			return errors.Wrapf(err, "var '%s'", name)
+		}
+		vars[name] = rendered
+	}
+	d.Vars = vars
+
+	data := render.NewData(vars, machine)
+	for id, s := range d.Phases {
+		if s == nil {
+			continue
+		}
+		if !s.IsRaw() {
+			if err := render.Strings(&s.BaseDependable, data); err != nil {
+				return errors.Wrapf(err, "%s: stage '%s'", s.Source, id)
+			}
+		}
+		for _, m := range s.Declared {
+			if m.IsRaw() {
+				continue
+			}
+			if err := render.Strings(m, data); err != nil {
+				return errors.Wrapf(err, "%s: module '%s'", m.GetSource(), m.GetID())
+			}
+		}
+	}
+	return nil
+}
+
+// merge adds the included declaration. Values set in the including declaration take precedence,
+// stages with the same name are merged.
+func (d *Declaration) merge(included *Declaration) error {
+	d.Global.merge(&included.Global)
+	d.problems = append(d.problems, included.problems...)
+
+	for name, profile := range included.Profiles {
+		if _, ok := d.Profiles[name]; !ok {
+			if d.Profiles == nil {
+				d.Profiles = make(Profiles, len(included.Profiles))
+			}
+			d.Profiles[name] = profile
+		}
+	}
+	if len(d.UseProfiles) == 0 {
+		d.UseProfiles = included.UseProfiles
+	}
+
+	for name, value := range included.Vars {
+		if _, ok := d.Vars[name]; !ok {
+			if d.Vars == nil {
+				d.Vars = make(map[string]string, len(included.Vars))
+			}
+			d.Vars[name] = value
+		}
+	}
+
+	if d.Phases == nil {
+		d.Phases = make(Stages, len(included.Phases))
+	}
+	for id, stage := range included.Phases {
+		existing, ok := d.Phases[id]
+		if !ok || existing == nil {
+			d.Phases[id] = stage
+			continue
+		}
+		if stage == nil {
+			continue
+		}
+		if err := existing.merge(stage); err != nil {
+			return errors.Wrapf(err, "stage '%s'", id)
+		}
+	}
+	return nil
+}
+
+func (g *Global) merge(included *Global) {
+	for _, m := range included.PackageManagers {
+		if !g.hasManager(m) {
+			g.PackageManagers = append(g.PackageManagers, m)
+		}
+	}
+	if g.Concurrency == 0 {
+		g.Concurrency = included.Concurrency
+	}
+	if g.HostOverlays == "" {
+		g.HostOverlays = included.HostOverlays
+	}
+	if g.Shell == "" {
+		g.Shell = included.Shell
+	}
+	if g.Retries == 0 {
+		g.Retries = included.Retries
+	}
+	if g.RetryDelay == 0 {
+		g.RetryDelay = included.RetryDelay
+	}
+	if g.Timeout == 0 {
+		g.Timeout = included.Timeout
+	}
+}
+
+func (g *Global) hasManager(cfg *pkgmanager.Config) bool {
+	for _, m := range g.PackageManagers {
+		if m.Name == cfg.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// merge adds the modules and dependencies of the other declaration of the same stage.
+// The same module declared in both is a conflict.
+func (s *Stage) merge(other *Stage) error {
+	declared := make(map[module.ID]module.Module, len(s.Declared))
+	for _, m := range s.Declared {
+		declared[m.GetID()] = m
+	}
+	for _, m := range other.Declared {
+		if existing, ok := declared[m.GetID()]; ok {
+			return errors.Errorf(
+				"module '%s' is declared in %s and %s", m.GetID(), existing.GetSource(), m.GetSource(),
+			)
+		}
+	}
+	s.Declared = append(s.Declared, other.Declared...)
+	s.problems = append(s.problems, other.problems...)
+
+	dependencies := s.Dependencies.Unique()
+	for _, d := range other.Dependencies {
+		if _, ok := dependencies[d]; !ok {
+			s.Dependencies = append(s.Dependencies, d)
+		}
+	}
+	// The tags are a union, so the profiles selecting either declaration by tag select the stage.
+	for _, t := range other.Tags {
+		if !s.HasTag(t) {
+			s.Tags = append(s.Tags, t)
+		}
+	}
+	if s.Description == "" {
+		s.Description = other.Description
+	}
+	if s.Version == "" {
+		s.Version = other.Version
+	}
+	if s.When == "" {
+		s.When = other.When
+	}
+	if s.Retries == nil {
+		s.Retries = other.Retries
+	}
+	if s.RetryDelay == nil {
+		s.RetryDelay = other.RetryDelay
+	}
+	if s.Timeout == nil {
+		s.Timeout = other.Timeout
+	}
+	s.Raw = s.Raw || other.Raw
+	return nil
+}
+
+// overlay applies the declaration on top, unlike merge the values set in the overlay take precedence
+// and modules with the same ID replace the declared ones.
+func (d *Declaration) overlay(o *Declaration) {
+	d.problems = append(d.problems, o.problems...)
+	for _, m := range o.Global.PackageManagers {
+		replaced := false
+		for i, existing := range d.Global.PackageManagers {
+			if existing.Name == m.Name {
+				d.Global.PackageManagers[i], replaced = m, true
+			}
+		}
+		if !replaced {
+			d.Global.PackageManagers = append(d.Global.PackageManagers, m)
+		}
+	}
+	if o.Global.Concurrency != 0 {
+		d.Global.Concurrency = o.Global.Concurrency
+	}
+	if o.Global.Shell != "" {
+		d.Global.Shell = o.Global.Shell
+	}
+	if o.Global.Retries != 0 {
+		d.Global.Retries = o.Global.Retries
+	}
+	if o.Global.RetryDelay != 0 {
+		d.Global.RetryDelay = o.Global.RetryDelay
+	}
+	if o.Global.Timeout != 0 {
+		d.Global.Timeout = o.Global.Timeout
+	}
+
+	for name, profile := range o.Profiles {
+		if d.Profiles == nil {
+			d.Profiles = make(Profiles, len(o.Profiles))
+		}
+		d.Profiles[name] = profile
+	}
+	if len(o.UseProfiles) > 0 {
+		d.UseProfiles = o.UseProfiles
+	}
+
+	for name, value := range o.Vars {
+		if d.Vars == nil {
+			d.Vars = make(map[string]string, len(o.Vars))
+		}
+		d.Vars[name] = value
+	}
+
+	if d.Phases == nil {
+		d.Phases = make(Stages, len(o.Phases))
+	}
+	for id, stage := range o.Phases {
+		existing, ok := d.Phases[id]
+		if !ok || existing == nil {
+			d.Phases[id] = stage
+			continue
+		}
+		if stage != nil {
+			existing.overlay(stage)
+		}
+	}
+}
+
+func (s *Stage) overlay(o *Stage) {
+	s.problems = append(s.problems, o.problems...)
+	for _, m := range o.Declared {
+		replaced := false
+		for i, existing := range s.Declared {
+			if existing.GetID() == m.GetID() {
+				s.Declared[i], replaced = m, true
+			}
+		}
+		if !replaced {
+			s.Declared = append(s.Declared, m)
+		}
+	}
+
+	dependencies := s.Dependencies.Unique()
+	for _, d := range o.Dependencies {
+		if _, ok := dependencies[d]; !ok {
+			s.Dependencies = append(s.Dependencies, d)
+		}
+	}
+	if o.Description != "" {
+		s.Description = o.Description
+	}
+	if o.Version != "" {
+		s.Version = o.Version
+	}
+	if o.When != "" {
+		s.When = o.When
+	}
+	if o.Retries != nil {
+		s.Retries = o.Retries
+	}
+	if o.RetryDelay != nil {
+		s.RetryDelay = o.RetryDelay
+	}
+	if o.Timeout != nil {
+		s.Timeout = o.Timeout
+	}
+	if o.Raw {
+		s.Raw = true
+	}
+	if len(o.Tags) > 0 {
+		s.Tags = o.Tags
+	}
+}
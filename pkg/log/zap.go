@@ -121,7 +121,7 @@ func newZapLogger(cfg *Config) *zap.Logger {
 
 	atom := zap.NewAtomicLevel()
 	atom.SetLevel(mapVerbosityLevel(cfg.LogVerbosity))
-	core := zapcore.NewCore(jsonEncoder, zapcore.Lock(os.Stdout), atom)
+	core := zapcore.NewCore(jsonEncoder, zapcore.Lock(os.Stderr), atom)
 
 	logger := zap.New(core).WithOptions(
 		zap.AddCallerSkip(2),
@@ -2,18 +2,13 @@ package module
 
 import (
 	"fmt"
-	"os"
+	"time"
 
-	"github.com/fatih/color"
 	"github.com/stevenle/topsort"
 
 	"github.com/tenderly/furnish/pkg/log"
 )
 
-var fmtNoDep = color.RedString("module is a dependency of") + color.HiMagentaString(" '%s' ") +
-	color.RedString("but not found:") + color.HiMagentaString(" '%s' \n")
-var fmtRemove = color.HiRedString("[fatal] please add the module or remove it as a dependency\n")
-
 type Related interface {
 	GetParent() ID
 	SetParent(ID)
@@ -25,10 +20,19 @@ type Dependable interface {
 	Identifier
 
 	GetDependencies() IDs
+	GetTags() []string
+	HasTag(...string) bool
+	GetWhen() string
+	GetRetries() (int, bool)
+	GetRetryDelay() (time.Duration, bool)
+	GetTimeout() (time.Duration, bool)
+	IsRaw() bool
 	GetDependants() IDs
 	IsDependency() bool
 	AddDependencies(...ID)
 	AddDependants(...ID)
+	GetSource() Source
+	SetSource(Source)
 
 	mustEmbedBaseDependable()
 }
@@ -39,14 +43,28 @@ var (
 )
 
 type BaseDependable struct {
-	ID           ID      `yaml:"id"           json:"id"`
-	Version      Version `yaml:"version"      json:"version"`
-	Description  string  `yaml:"description"  json:"description"`
-	Dependencies IDs     `yaml:"dependencies" json:"dependencies"`
-	Dependants   IDs     `yaml:"-"            json:"dependants"`
-
-	Children IDs `yaml:"-" json:"children,omitempty"`
-	Parent   ID  `yaml:"-" json:"parent"`
+	ID           ID       `yaml:"id"           json:"id"`
+	Version      Version  `yaml:"version"      json:"version"`
+	Description  string   `yaml:"description"  json:"description"`
+	Dependencies IDs      `yaml:"dependencies" json:"dependencies"`
+	Tags         []string `yaml:"tags"         json:"tags,omitempty"`
+	// When is a condition on the facts, e.g. os == "darwin", the dependable is skipped if it's false.
+	When string `yaml:"when" json:"when,omitempty"`
+	// Retries is how many times a failing module is applied again, RetryDelay is the delay before the first
+	// retry and it doubles with every next one. Unset values fall back to the stage and the global ones.
+	Retries    *int           `yaml:"retries,omitempty"     json:"retries,omitempty"`
+	RetryDelay *time.Duration `yaml:"retry-delay,omitempty" json:"retry_delay,omitempty"`
+	// Timeout limits every attempt of applying the module, unset falls back to the stage and the global one.
+	Timeout *time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// Raw leaves the templates in the fields as they are, e.g. for a command with its own Go templates.
+	Raw bool `yaml:"raw" json:"raw,omitempty"`
+
+	Dependants IDs  `yaml:"-" render:"-" json:"dependants"`
+	Children   IDs  `yaml:"-" render:"-" json:"children,omitempty"`
+	Parent     ID   `yaml:"-" render:"-" json:"parent"`
+	Kind       Kind `yaml:"-" render:"-" json:"kind,omitempty"`
+
+	Source Source `yaml:"-" render:"-" json:"source"`
 }
 
 func (bd *BaseDependable) GetID() ID { return bd.ID }
@@ -57,6 +75,48 @@ func (bd *BaseDependable) GetVersion() Version { return bd.Version }
 
 func (bd *BaseDependable) GetDependencies() IDs { return bd.Dependencies }
 
+func (bd *BaseDependable) GetTags() []string { return bd.Tags }
+
+// HasTag reports whether the dependable has any of the tags.
+func (bd *BaseDependable) HasTag(tags ...string) bool {
+	for _, t := range tags {
+		for _, own := range bd.Tags {
+			if t == own {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (bd *BaseDependable) GetWhen() string { return bd.When }
+
+// GetRetries returns the retries and whether they are set.
+func (bd *BaseDependable) GetRetries() (int, bool) {
+	if bd.Retries == nil {
+		return 0, false
+	}
+	return *bd.Retries, true
+}
+
+// GetRetryDelay returns the delay before the first retry and whether it's set.
+func (bd *BaseDependable) GetRetryDelay() (time.Duration, bool) {
+	if bd.RetryDelay == nil {
+		return 0, false
+	}
+	return *bd.RetryDelay, true
+}
+
+// GetTimeout returns the timeout and whether it's set.
+func (bd *BaseDependable) GetTimeout() (time.Duration, bool) {
+	if bd.Timeout == nil {
+		return 0, false
+	}
+	return *bd.Timeout, true
+}
+
+func (bd *BaseDependable) IsRaw() bool { return bd.Raw }
+
 func (bd *BaseDependable) GetDependants() IDs { return bd.Dependants }
 
 func (bd *BaseDependable) IsDependency() bool { return len(bd.Dependants) > 0 }
@@ -77,6 +137,14 @@ func (bd *BaseDependable) GetChildren() IDs { return bd.Children }
 
 func (bd *BaseDependable) AddChildren(ids ...ID) { bd.Children = append(bd.Children, ids...) }
 
+func (bd *BaseDependable) GetKind() Kind { return bd.Kind }
+
+func (bd *BaseDependable) SetKind(kind Kind) { bd.Kind = kind }
+
+func (bd *BaseDependable) GetSource() Source { return bd.Source }
+
+func (bd *BaseDependable) SetSource(src Source) { bd.Source = src }
+
 func (bd *BaseDependable) mustEmbedBaseDependable() {}
 
 type Dependables []Dependable
@@ -89,9 +157,13 @@ func (dd Dependables) Map() map[ID]Dependable {
 	return dependerMap
 }
 
-func (dd Dependables) Sort() Dependables {
+// Sort orders the dependables so that every dependable comes after its dependencies.
+// It returns ErrMissingDependency if a dependency isn't one of the dependables and ErrDependencyCycle
+// if the dependables depend on each other in a cycle. Qualified dependencies,
+// stage/module, which aren't one of the dependables are left to Stages.Sorted, they refer to other stages.
+func (dd Dependables) Sort() (Dependables, error) {
 	if len(dd) == 0 {
-		return dd
+		return dd, nil
 	}
 
 	sortedRelaters := make(Dependables, 0, len(dd))
@@ -111,25 +183,31 @@ func (dd Dependables) Sort() Dependables {
 	}
 	for _, r := range dd {
 		for _, d := range r.GetDependencies() {
-			graph.AddEdge(r.GetID().String(), d.String())
 			dependency, ok := relaterMap[d]
+			if _, _, qualified := d.Split(); !ok && qualified {
+				continue
+			}
+			graph.AddEdge(r.GetID().String(), d.String())
 			if !ok {
-				fmt.Printf(fmtNoDep, r.GetID(), dependency)
-				fmt.Printf(fmtRemove)
-				os.Exit(1)
+				return nil, &ErrMissingDependency{Stage: parentOf(r), Module: r.GetID(), Dependency: d}
 			}
 			dependency.AddDependants(r.GetID())
 		}
 	}
 
+	// The topological sort fails on the first cycle without telling where it is, the whole path is more useful.
+	if cycles := dd.Cycles(); len(cycles) > 0 {
+		return nil, &ErrDependencyCycle{Stage: parentOf(dd[0]), Path: cycles[0]}
+	}
+
 	for _, r := range dd {
 		if _, ok := added[r.GetID()]; ok {
 			continue
 		}
 
-		sorted, edd := graph.TopSort(r.GetID().String())
-		if edd != nil {
-			log.Error("something went wrong when sorting", "edd", edd)
+		sorted, err := graph.TopSort(r.GetID().String())
+		if err != nil {
+			return nil, fmt.Errorf("sorting '%s': %w", r.GetID(), err)
 		}
 
 		for _, id := range sorted {
@@ -142,5 +220,58 @@ func (dd Dependables) Sort() Dependables {
 		}
 	}
 
-	return sortedRelaters
+	return sortedRelaters, nil
+}
+
+// Cycles returns the dependency cycles between the dependables, every cycle as the path which starts
+// and ends with the same ID. Dependencies which aren't one of the dependables are ignored.
+func (dd Dependables) Cycles() []IDs {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	dependerMap := dd.Map()
+	state := make(map[ID]int, len(dd))
+	cycles := make([]IDs, 0)
+	path := make(IDs, 0, len(dd))
+
+	var visit func(r Dependable)
+	visit = func(r Dependable) {
+		state[r.GetID()] = visiting
+		path = append(path, r.GetID())
+		for _, d := range r.GetDependencies() {
+			dependency, ok := dependerMap[d]
+			if !ok {
+				continue
+			}
+			switch state[d] {
+			case visiting:
+				for i, id := range path {
+					if id == d {
+						cycle := append(IDs{}, path[i:]...)
+						cycles = append(cycles, append(cycle, d))
+						break
+					}
+				}
+			case 0:
+				visit(dependency)
+			}
+		}
+		path = path[:len(path)-1]
+		state[r.GetID()] = visited
+	}
+
+	for _, r := range dd {
+		if state[r.GetID()] == 0 {
+			visit(r)
+		}
+	}
+	return cycles
+}
+
+func parentOf(d Dependable) ID {
+	if related, ok := d.(Related); ok {
+		return related.GetParent()
+	}
+	return ""
 }
@@ -0,0 +1,251 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type testModule struct {
+	BaseDependable
+}
+
+func (*testModule) IsOptional() bool                              { return false }
+func (*testModule) IsMandatory() bool                             { return false }
+func (*testModule) Apply(context.Context) (bool, string, error)   { return true, "", nil }
+func (*testModule) Check(context.Context) (Action, string, error) { return ActionRun, "", nil }
+
+type testStage struct {
+	BaseDependable
+	modules Modules
+}
+
+func (*testStage) Initialize() error  { return nil }
+func (s *testStage) Modules() Modules { return s.modules }
+
+// testModuleOf returns a module of the kind in the stage, depending on the dependencies.
+func testModuleOf(stage ID, kind Kind, id ID, dependencies ...ID) *testModule {
+	return &testModule{BaseDependable{ID: id, Kind: kind, Parent: stage, Dependencies: dependencies}}
+}
+
+func testStageOf(id ID, modules ...Module) *testStage {
+	return &testStage{BaseDependable: BaseDependable{ID: id}, modules: modules}
+}
+
+// assertOrder checks that every dependable comes after its dependencies among the sorted ones.
+func assertOrder(t *testing.T, sorted Dependables, want int) {
+	t.Helper()
+	if len(sorted) != want {
+		t.Fatalf("sorted %d dependables, want %d", len(sorted), want)
+	}
+	position := make(map[ID]int, len(sorted))
+	for i, d := range sorted {
+		position[d.GetID()] = i
+	}
+	for _, d := range sorted {
+		for _, dep := range d.GetDependencies() {
+			at, ok := position[dep]
+			if !ok {
+				continue
+			}
+			if at > position[d.GetID()] {
+				t.Errorf("'%s' is sorted before its dependency '%s'", d.GetID(), dep)
+			}
+		}
+	}
+}
+
+func TestDependablesSort(t *testing.T) {
+	tests := []struct {
+		name    string
+		modules []*testModule
+		// cycle is the expected cycle error, empty if the modules sort.
+		cycle string
+		path  IDs
+	}{
+		{
+			name: "independent",
+			modules: []*testModule{
+				testModuleOf("base", "shell", "a"),
+				testModuleOf("base", "shell", "b"),
+			},
+		},
+		{
+			name: "chain",
+			modules: []*testModule{
+				testModuleOf("base", "shell", "c", "b"),
+				testModuleOf("base", "shell", "b", "a"),
+				testModuleOf("base", "shell", "a"),
+			},
+		},
+		{
+			name: "diamond",
+			modules: []*testModule{
+				testModuleOf("base", "shell", "top", "left", "right"),
+				testModuleOf("base", "shell", "left", "bottom"),
+				testModuleOf("base", "shell", "right", "bottom"),
+				testModuleOf("base", "shell", "bottom"),
+			},
+		},
+		{
+			name: "across kinds",
+			modules: []*testModule{
+				testModuleOf("base", "shell", "configure", "git"),
+				testModuleOf("base", "packages", "git", "xcode-select"),
+				testModuleOf("base", "xcode-select", "xcode-select"),
+			},
+		},
+		{
+			name: "qualified dependency on another stage",
+			modules: []*testModule{
+				testModuleOf("base", "shell", "configure", "tools/git"),
+				testModuleOf("base", "shell", "git"),
+			},
+		},
+		{
+			name: "self dependency",
+			modules: []*testModule{
+				testModuleOf("base", "shell", "a", "a"),
+			},
+			cycle: "dependency cycle in stage 'base': a -> a",
+			path:  IDs{"a", "a"},
+		},
+		{
+			name: "cycle",
+			modules: []*testModule{
+				testModuleOf("base", "shell", "a", "b"),
+				testModuleOf("base", "shell", "b", "c"),
+				testModuleOf("base", "shell", "c", "a"),
+			},
+			cycle: "dependency cycle in stage 'base': a -> b -> c -> a",
+			path:  IDs{"a", "b", "c", "a"},
+		},
+		{
+			name: "cycle across kinds",
+			modules: []*testModule{
+				testModuleOf("base", "packages", "git", "configure"),
+				testModuleOf("base", "shell", "configure", "ssh"),
+				testModuleOf("base", "ssh", "ssh", "git"),
+			},
+			cycle: "dependency cycle in stage 'base': git -> configure -> ssh -> git",
+			path:  IDs{"git", "configure", "ssh", "git"},
+		},
+		{
+			name: "cycle below a diamond",
+			modules: []*testModule{
+				testModuleOf("base", "shell", "top", "left", "right"),
+				testModuleOf("base", "shell", "left", "bottom"),
+				testModuleOf("base", "shell", "right", "bottom"),
+				testModuleOf("base", "shell", "bottom", "right"),
+			},
+			cycle: "dependency cycle in stage 'base': bottom -> right -> bottom",
+			path:  IDs{"bottom", "right", "bottom"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dependables := make(Dependables, 0, len(tt.modules))
+			for _, m := range tt.modules {
+				dependables = append(dependables, m)
+			}
+
+			sorted, err := dependables.Sort()
+			if tt.cycle == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				assertOrder(t, sorted, len(tt.modules))
+				return
+			}
+
+			var cycle *ErrDependencyCycle
+			if !errors.As(err, &cycle) {
+				t.Fatalf("got error %v, want a dependency cycle", err)
+			}
+			if cycle.Error() != tt.cycle {
+				t.Errorf("got message %q, want %q", cycle.Error(), tt.cycle)
+			}
+			if cycle.Path.Path() != tt.path.Path() {
+				t.Errorf("got path %s, want %s", cycle.Path.Path(), tt.path.Path())
+			}
+		})
+	}
+}
+
+func TestDependablesSortMissingDependency(t *testing.T) {
+	_, err := Dependables{testModuleOf("base", "shell", "a", "b")}.Sort()
+
+	var missing *ErrMissingDependency
+	if !errors.As(err, &missing) {
+		t.Fatalf("got error %v, want a missing dependency", err)
+	}
+	if missing.Stage != "base" || missing.Module != "a" || missing.Dependency != "b" {
+		t.Errorf("got %+v", missing)
+	}
+}
+
+func TestStagesSorted(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages Stages
+		// order is the expected order of the stages, cycle the expected error.
+		order IDs
+		cycle string
+	}{
+		{
+			name: "declared dependency",
+			stages: Stages{
+				&testStage{
+					BaseDependable: BaseDependable{ID: "apps", Dependencies: IDs{"base"}},
+					modules:        Modules{testModuleOf("apps", "packages", "slack")},
+				},
+				testStageOf("base", testModuleOf("base", "packages", "git")),
+			},
+			order: IDs{"base", "apps"},
+		},
+		{
+			name: "qualified module dependency",
+			stages: Stages{
+				testStageOf("apps", testModuleOf("apps", "shell", "configure", "tools/git")),
+				testStageOf("tools", testModuleOf("tools", "packages", "git")),
+			},
+			order: IDs{"tools", "apps"},
+		},
+		{
+			name: "cycle through module dependencies",
+			stages: Stages{
+				testStageOf("apps", testModuleOf("apps", "shell", "configure", "tools/git")),
+				testStageOf("tools", testModuleOf("tools", "packages", "git", "apps/configure")),
+			},
+			cycle: "dependency cycle between stages: apps -> tools -> apps",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted, _, err := tt.stages.Sorted()
+			if tt.cycle != "" {
+				var cycle *ErrDependencyCycle
+				if !errors.As(err, &cycle) {
+					t.Fatalf("got error %v, want a dependency cycle", err)
+				}
+				if cycle.Error() != tt.cycle {
+					t.Errorf("got message %q, want %q", cycle.Error(), tt.cycle)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			order := make(IDs, 0, len(sorted))
+			for _, s := range sorted {
+				order = append(order, s.GetID())
+			}
+			if order.Path() != tt.order.Path() {
+				t.Errorf("got stages %s, want %s", order.Path(), tt.order.Path())
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package module
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrMissingDependency is returned when a module or a stage depends on something which isn't declared.
+// Stage is empty when the dependable is a stage itself.
+type ErrMissingDependency struct {
+	Stage      ID
+	Module     ID
+	Dependency ID
+}
+
+func (e *ErrMissingDependency) Error() string {
+	if e.Stage == "" {
+		return fmt.Sprintf("stage '%s' depends on '%s' but it's not found", e.Module, e.Dependency)
+	}
+	return fmt.Sprintf("module '%s' in stage '%s' depends on '%s' but it's not found", e.Module, e.Stage, e.Dependency)
+}
+
+// ErrDependencyCycle is returned when dependables depend on each other in a cycle, nothing can be applied.
+// Path starts and ends with the same ID, Stage is empty for a cycle between stages.
+type ErrDependencyCycle struct {
+	Stage ID
+	Path  IDs
+}
+
+func (e *ErrDependencyCycle) Error() string {
+	if e.Stage == "" {
+		return fmt.Sprintf("dependency cycle between stages: %s", e.Path.Path())
+	}
+	return fmt.Sprintf("dependency cycle in stage '%s': %s", e.Stage, e.Path.Path())
+}
+
+// ErrTimedOut is returned when applying a module takes longer than its timeout, the module is killed.
+type ErrTimedOut struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *ErrTimedOut) Error() string {
+	return fmt.Sprintf("timed out after %s: %s", e.Timeout, e.Err)
+}
+
+func (e *ErrTimedOut) Unwrap() error { return e.Err }
+
+// ErrInterrupted is returned when the run is cancelled, e.g. by a signal. Stage is the stage
+// the run stopped in.
+type ErrInterrupted struct {
+	Stage ID
+	Err   error
+}
+
+func (e *ErrInterrupted) Error() string {
+	return fmt.Sprintf("interrupted in stage '%s': %s", e.Stage, e.Err)
+}
+
+func (e *ErrInterrupted) Unwrap() error { return e.Err }
+
+// ErrMandatoryFailed is returned when applying a mandatory module fails, it aborts the run.
+type ErrMandatoryFailed struct {
+	Stage  ID
+	Module ID
+	Err    error
+}
+
+func (e *ErrMandatoryFailed) Error() string {
+	return fmt.Sprintf("mandatory module '%s' in stage '%s' failed: %s", e.Module, e.Stage, e.Err)
+}
+
+func (e *ErrMandatoryFailed) Unwrap() error { return e.Err }
+
+// ErrDependencyFailed is returned when applying a module others depend on fails, it aborts the run
+// once the rest of the stage is done.
+type ErrDependencyFailed struct {
+	Stage      ID
+	Module     ID
+	Dependants IDs
+	Err        error
+}
+
+func (e *ErrDependencyFailed) Error() string {
+	return fmt.Sprintf(
+		"module '%s' in stage '%s' is a dependency for %v and failed: %s", e.Module, e.Stage, e.Dependants, e.Err,
+	)
+}
+
+func (e *ErrDependencyFailed) Unwrap() error { return e.Err }
@@ -0,0 +1,19 @@
+package module
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Hash returns a digest of the declared module configuration. Fields which aren't declared in yaml,
+// such as the dependants or the parent, don't change it.
+func Hash(m Module) string {
+	declared, err := yaml.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(declared)
+	return hex.EncodeToString(sum[:])
+}
@@ -1,5 +1,7 @@
 package module
 
+import "strings"
+
 type Identifier interface {
 	GetID() ID
 	GetDescription() string
@@ -10,6 +12,19 @@ type ID string
 
 func (id ID) String() string { return string(id) }
 
+// Qualify returns the ID of the module which is unique across stages, stage/module.
+func Qualify(stage, id ID) ID { return stage + "/" + id }
+
+// Split splits a qualified ID into the stage and the module. Module IDs can contain slashes as well,
+// e.g. brew taps, so a dependency is only treated as qualified if it doesn't match a module of its own stage.
+func (id ID) Split() (ID, ID, bool) {
+	stage, module, ok := strings.Cut(id.String(), "/")
+	if !ok || stage == "" || module == "" {
+		return "", "", false
+	}
+	return ID(stage), ID(module), true
+}
+
 type IDs []ID
 
 func (ids IDs) Unique() UniqueIDs {
@@ -22,6 +37,15 @@ func (ids IDs) Unique() UniqueIDs {
 	return unique
 }
 
+// Path joins the IDs with arrows, e.g. to print a dependency path.
+func (ids IDs) Path() string {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, id.String())
+	}
+	return strings.Join(parts, " -> ")
+}
+
 type UniqueIDs map[ID]struct{}
 
 func (uids UniqueIDs) Iterate() chan ID {
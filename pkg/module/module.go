@@ -10,9 +10,24 @@ type Module interface {
 	Dependable
 	Related
 
+	GetKind() Kind
+	SetKind(Kind)
 	IsOptional() bool
 	IsMandatory() bool
 	Apply(context.Context) (bool, string, error)
+	// Check reports what Apply would do without changing anything on the machine.
+	Check(context.Context) (Action, string, error)
+}
+
+// Validator is implemented by modules which can check their configuration before anything is applied.
+type Validator interface {
+	Validate() error
+}
+
+// Applier is implemented by modules which can be applied in more than one way,
+// e.g. packages which can be installed, updated or deleted.
+type Applier interface {
+	GetApplier() string
 }
 
 type Modules []Module
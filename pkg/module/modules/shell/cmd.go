@@ -1,85 +1,168 @@
 package shell
 
 import (
-	"fmt"
+	"bytes"
+	"context"
 	"os"
 	"os/exec"
+	"sync"
 
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/pkg/errors"
+
+	"github.com/tenderly/furnish/pkg/module"
 )
 
+// shell runs the commands, it's set from the global config.
 var shell = "/bin/bash"
 
-func init() {
-	if BinaryExists("zsh") {
-		shell = "/bin/zsh"
-		color.HiBlue("[init] zsh found: shell changed to zsh")
+// running contains the commands which are running and whether they have a process group of their own.
+var running = struct {
+	sync.Mutex
+	cmds map[*exec.Cmd]bool
+}{cmds: make(map[*exec.Cmd]bool)}
+
+// SetShell sets the shell running the commands, a path or a name looked up in PATH.
+func SetShell(name string) error {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return errors.Wrapf(err, "shell '%s' not found", name)
 	}
+	shell = path
+	color.HiBlue("[init] using shell %s", shell)
+	return nil
 }
 
+// BinaryExists reports whether the binary is an executable path or can be found in PATH.
 func BinaryExists(pkg string) bool {
-	if err := exec.Command(shell, "-c", fmt.Sprintf("which -s %s", pkg)).Run(); err != nil {
+	if _, err := exec.LookPath(pkg); err != nil {
 		return false
 	}
 	return true
 }
 
-func Exec(args ...string) error {
+func Exec(ctx context.Context, args ...string) error {
 	allArgs := append(append(make([]string, 0, len(args)+1), "-c"), args...)
-	cmd := exec.Command(shell, allArgs...)
-
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-
-	if err := cmd.Run(); err != nil {
-		return err
-	}
+	return run(ctx, interactive(ctx, exec.Command(shell, allArgs...)))
+}
 
-	return nil
+func ExecSilent(ctx context.Context, args ...string) error {
+	allArgs := append(append(make([]string, 0, len(args)+1), "-c"), args...)
+	return run(ctx, exec.Command(shell, allArgs...))
 }
 
-func ExecSilent(args ...string) error {
+func ExecOutput(ctx context.Context, args ...string) (string, error) {
 	allArgs := append(append(make([]string, 0, len(args)+1), "-c"), args...)
-	if err := exec.Command(shell, allArgs...).Run(); err != nil {
-		return err
-	}
-	return nil
+	return output(ctx, exec.Command(shell, allArgs...))
 }
 
-func ExecOutput(args ...string) (string, error) {
+// ExecCombinedOutput runs the command silently and returns what it wrote to stdout and stderr,
+// so the caller can report why it failed.
+func ExecCombinedOutput(ctx context.Context, args ...string) (string, error) {
 	allArgs := append(append(make([]string, 0, len(args)+1), "-c"), args...)
-	out, err := exec.Command(shell, allArgs...).Output()
-	if err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%s", out), nil
+	cmd := exec.Command(shell, allArgs...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := run(ctx, cmd)
+	return out.String(), err
 }
 
-func Script(path string) error {
-	cmd := exec.Command(shell, path)
+func Script(ctx context.Context, path string) error {
+	return run(ctx, interactive(ctx, exec.Command(shell, path)))
+}
+
+func ScriptSilent(ctx context.Context, path string) error {
+	return run(ctx, exec.Command(shell, path))
+}
+
+func ScriptOutput(ctx context.Context, path string) (string, error) {
+	return output(ctx, exec.Command(shell, path))
+}
+
+// interactive connects the command to the standard streams, unless the context redirects the output
+// of the module, then the command writes there and doesn't get any input.
+func interactive(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	if w := module.Output(ctx); w != nil {
+		cmd.Stdout = w
+		cmd.Stderr = w
+		return cmd
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
+	return cmd
+}
 
-	if err := cmd.Run(); err != nil {
-		return err
+func output(ctx context.Context, cmd *exec.Cmd) (string, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := run(ctx, cmd); err != nil {
+		return "", err
 	}
-
-	return nil
+	return out.String(), nil
 }
 
-func ScriptSilent(path string) error {
-	if err := exec.Command(shell, path).Run(); err != nil {
+// run runs the command until it exits or the context is done, in which case the command is killed
+// with the processes it started and the error of the context is returned. The command runs in a process
+// group of its own, unless it reads from a terminal: a process outside of the terminal's foreground
+// group is stopped once it reads. Such a command shares the foreground group with furnish, so Ctrl-C
+// interrupts it right away and the grace period doesn't apply to it.
+func run(ctx context.Context, cmd *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	return nil
+	group := !readsTerminal(cmd)
+	if group {
+		setProcessGroup(cmd)
+	}
+	running.Lock()
+	if err := cmd.Start(); err != nil {
+		running.Unlock()
+		return err
+	}
+	running.cmds[cmd] = group
+	running.Unlock()
+	defer func() {
+		running.Lock()
+		delete(running.cmds, cmd)
+		running.Unlock()
+	}()
+
+	exited := make(chan struct{})
+	killed := make(chan struct{})
+	go func() {
+		defer close(killed)
+		select {
+		case <-ctx.Done():
+			kill(cmd, group)
+		case <-exited:
+		}
+	}()
+	err := cmd.Wait()
+	close(exited)
+	<-killed
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
 }
 
-func ScriptOutput(path string) (string, error) {
-	out, err := exec.Command(shell, path).Output()
-	if err != nil {
-		return "", err
+// KillAll kills the running commands, used when furnish has to exit without waiting for them.
+func KillAll() {
+	running.Lock()
+	defer running.Unlock()
+	for cmd, group := range running.cmds {
+		kill(cmd, group)
+	}
+}
+
+func readsTerminal(cmd *exec.Cmd) bool {
+	file, ok := cmd.Stdin.(*os.File)
+	if !ok {
+		return false
 	}
-	return fmt.Sprintf("%s", out), nil
+	return isatty.IsTerminal(file.Fd())
 }
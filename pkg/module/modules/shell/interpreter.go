@@ -0,0 +1,110 @@
+package shell
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// inlineFlags are the flags of the known interpreters which run the code passed as an argument.
+var inlineFlags = map[string]string{
+	"sh":      "-c",
+	"bash":    "-c",
+	"zsh":     "-c",
+	"dash":    "-c",
+	"ksh":     "-c",
+	"fish":    "-c",
+	"python":  "-c",
+	"python3": "-c",
+	"perl":    "-e",
+	"ruby":    "-e",
+	"node":    "-e",
+}
+
+// Interpreter is the argv running a command or a script, declared as a name, e.g. python3, or as a list,
+// e.g. [python3, -u]. Commands are passed with the inline flag of the known interpreters, an unknown
+// interpreter gets the command as its last argument. Scripts are passed as a path followed by their args.
+type Interpreter []string
+
+func (i *Interpreter) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*i = Interpreter{node.Value}
+		return nil
+	}
+	var argv []string
+	if err := node.Decode(&argv); err != nil {
+		return errors.Wrap(err, "interpreter must be a name or a list of arguments")
+	}
+	*i = argv
+	return nil
+}
+
+func (i Interpreter) command(cmd string) []string {
+	argv := append(make([]string, 0, len(i)+2), i...)
+	if flag, ok := inlineFlags[filepath.Base(i[0])]; ok {
+		argv = append(argv, flag)
+	}
+	return append(argv, cmd)
+}
+
+func (i Interpreter) script(path string, args []string) []string {
+	argv := append(make([]string, 0, len(i)+len(args)+1), i...)
+	return append(append(argv, path), args...)
+}
+
+// Command runs commands and scripts with an interpreter, environment and working directory.
+// The zero value runs them with the shell, like Exec and Script.
+type Command struct {
+	Interpreter Interpreter
+	// Env is added to the environment of furnish.
+	Env    map[string]string
+	Dir    string
+	Silent bool
+}
+
+// Exec runs the command with the interpreter.
+func (c *Command) Exec(ctx context.Context, cmd string) error {
+	return c.run(ctx, c.interpreter().command(cmd))
+}
+
+// Script runs the script file with the interpreter.
+func (c *Command) Script(ctx context.Context, path string, args []string) error {
+	return c.run(ctx, c.interpreter().script(path, args))
+}
+
+// Executable runs the executable file itself, e.g. a script with a shebang.
+func (c *Command) Executable(ctx context.Context, path string, args []string) error {
+	return c.run(ctx, append([]string{path}, args...))
+}
+
+func (c *Command) interpreter() Interpreter {
+	if len(c.Interpreter) == 0 {
+		return Interpreter{shell}
+	}
+	return c.Interpreter
+}
+
+func (c *Command) run(ctx context.Context, argv []string) error {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = c.Dir
+	if len(c.Env) > 0 {
+		names := make([]string, 0, len(c.Env))
+		for name := range c.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		cmd.Env = os.Environ()
+		for _, name := range names {
+			cmd.Env = append(cmd.Env, name+"="+c.Env[name])
+		}
+	}
+	if !c.Silent {
+		interactive(ctx, cmd)
+	}
+	return run(ctx, cmd)
+}
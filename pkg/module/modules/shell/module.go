@@ -2,16 +2,38 @@ package shell
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 
 	"github.com/tenderly/furnish/pkg/module"
 )
 
+const Kind module.Kind = "shell"
+
+func init() { module.Register(Kind, decode) }
+
+func decode(node *yaml.Node) (module.Modules, error) {
+	return module.DecodeSequence(node, func(item *yaml.Node) (module.Module, error) {
+		x := &Execution{}
+		return x, item.Decode(x)
+	})
+}
+
 type Shell []*Execution
 
-var _ module.Module = (*Execution)(nil)
+var (
+	_ module.Module       = (*Execution)(nil)
+	_ module.PathResolver = (*Execution)(nil)
+	_ module.Validator    = (*Execution)(nil)
+	_ module.Interactive  = (*Execution)(nil)
+)
 
 type Execution struct {
 	module.BaseDependable `yaml:",inline"`
@@ -21,63 +43,237 @@ type Execution struct {
 	File      string    `yaml:"file"   json:"file,omitempty"`
 	Silent    bool      `yaml:"silent" json:"silent,omitempty"`
 	Mandatory bool      `yaml:"mandatory" json:"mandatory,omitempty"`
+	// Interactive marks a command reading from the terminal, e.g. a sudo prompt. It keeps the terminal
+	// when modules are applied in parallel, the others wait for it.
+	Interactive bool `yaml:"interactive" json:"interactive,omitempty"`
+	// Script is an inline script, it's run from a temporary file. A script starting with a shebang
+	// is run by it unless the shell or the interpreter is set.
+	Script string `yaml:"script" json:"script,omitempty"`
+	// SHA256 pins the content of the file, it isn't run if the content changed.
+	SHA256 string `yaml:"sha256" json:"sha256,omitempty"`
+	// Creates, Unless and OnlyIf guard the execution, it's skipped when the path exists, when unless
+	// succeeds or when onlyif fails.
+	Creates string `yaml:"creates" json:"creates,omitempty"`
+	Unless  string `yaml:"unless"  json:"unless,omitempty"`
+	OnlyIf  string `yaml:"onlyif"  json:"onlyif,omitempty"`
+	// Shell runs the cmd or the script instead of the global shell, Interpreter runs them with
+	// any program, e.g. python3 or [node, --no-warnings].
+	Shell       string            `yaml:"shell"       json:"shell,omitempty"`
+	Interpreter Interpreter       `yaml:"interpreter" json:"interpreter,omitempty"`
+	Args        []string          `yaml:"args"        json:"args,omitempty"`
+	Env         map[string]string `yaml:"env"         json:"env,omitempty"`
+	Dir         string            `yaml:"dir"         json:"dir,omitempty"`
 
 	meta string `yaml:"-" json:"-"`
 }
 
 func (x *Execution) GetID() module.ID { return x.Name }
 
+func (x *Execution) IsInteractive() bool { return x.Interactive }
+
 func (x *Execution) Validate() error {
 	if x.Name == "" {
 		return errors.New("shell execution must have name")
 	}
-	if x.Cmd == "" || x.File == "" {
-		return errors.New("shell execution must have a cmd or a script provided")
+	set := 0
+	for _, s := range []string{x.Cmd, x.File, x.Script} {
+		if s != "" {
+			set++
+		}
+	}
+	if set == 0 {
+		return errors.New("shell execution must have a cmd, a file or a script provided")
+	}
+	if set > 1 {
+		return errors.New("shell execution must have only one of cmd, file and script")
+	}
+	if x.File != "" {
+		if _, err := os.Stat(x.File); err != nil {
+			return errors.Errorf("script '%s' not found", x.File)
+		}
+	}
+	if x.SHA256 != "" {
+		if x.File == "" {
+			return errors.New("sha256 pins file executions only")
+		}
+		if err := x.verify(); err != nil {
+			return err
+		}
+	}
+	if len(x.Args) > 0 && x.Cmd != "" {
+		return errors.New("args are passed to scripts only, add them to the cmd")
+	}
+	if x.Shell != "" && len(x.Interpreter) > 0 {
+		return errors.New("shell execution must have either a shell or an interpreter, not both")
 	}
 	return nil
 }
 
+func (x *Execution) ResolvePaths(dir string) {
+	if x.File != "" && !filepath.IsAbs(x.File) {
+		x.File = filepath.Join(dir, x.File)
+	}
+	if x.Creates != "" && !filepath.IsAbs(x.Creates) {
+		x.Creates = filepath.Join(dir, x.Creates)
+	}
+	if x.Dir != "" && !filepath.IsAbs(x.Dir) {
+		x.Dir = filepath.Join(dir, x.Dir)
+	}
+}
+
 func (x *Execution) IsOptional() bool { return false }
 
 func (x *Execution) IsMandatory() bool { return x.Mandatory }
 
 func (x *Execution) Apply(ctx context.Context) (bool, string, error) {
 	x.setMeta()
-	if x.File != "" {
+	done, reason, err := x.done(ctx)
+	if err != nil {
+		return false, x.meta, err
+	}
+	if done {
+		return false, fmt.Sprintf("%s; %s", x.meta, reason), nil
+	}
+	switch {
+	case x.File != "":
+		return x.applyFile(ctx)
+	case x.Script != "":
 		return x.applyScript(ctx)
+	default:
+		return x.applyCmd(ctx)
 	}
-	return x.applyCmd(ctx)
 }
 
-func (x *Execution) applyScript(ctx context.Context) (bool, string, error) {
-	if x.Silent {
-		if err := ScriptSilent(x.File); err != nil {
-			return false, x.meta, errors.Wrap(err, "couldn't exec script")
+func (x *Execution) Check(ctx context.Context) (module.Action, string, error) {
+	x.setMeta()
+	done, reason, err := x.done(ctx)
+	if err != nil {
+		return module.ActionSkip, x.meta, err
+	}
+	if done {
+		return module.ActionSkip, fmt.Sprintf("%s; %s", x.meta, reason), nil
+	}
+	return module.ActionRun, x.meta, nil
+}
+
+// done evaluates the guards and reports whether the work of the execution is already done, with the reason.
+// The guards run silently with the interpreter, environment and directory of the execution.
+func (x *Execution) done(ctx context.Context) (bool, string, error) {
+	guard := x.command()
+	guard.Silent = true
+	if x.Creates != "" {
+		if _, err := os.Stat(x.Creates); err == nil {
+			return true, fmt.Sprintf("creates: '%s' exists", x.Creates), nil
+		}
+	}
+	if x.Unless != "" {
+		err := guard.Exec(ctx, x.Unless)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, "", errors.Wrap(ctxErr, "couldn't check unless")
+		}
+		if err == nil {
+			return true, fmt.Sprintf("unless: '%s' succeeded", x.Unless), nil
 		}
-		return true, x.meta, nil
 	}
-	if err := Script(x.File); err != nil {
+	if x.OnlyIf != "" {
+		err := guard.Exec(ctx, x.OnlyIf)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, "", errors.Wrap(ctxErr, "couldn't check onlyif")
+		}
+		if err != nil {
+			return true, fmt.Sprintf("onlyif: '%s' failed", x.OnlyIf), nil
+		}
+	}
+	return false, "", nil
+}
+
+func (x *Execution) applyFile(ctx context.Context) (bool, string, error) {
+	if err := x.verify(); err != nil {
+		return false, x.meta, err
+	}
+	if err := x.command().Script(ctx, x.File, x.Args); err != nil {
 		return false, x.meta, errors.Wrap(err, "couldn't exec script")
 	}
 	return true, x.meta, nil
 }
-func (x *Execution) applyCmd(ctx context.Context) (bool, string, error) {
-	if x.Silent {
-		if err := ExecSilent(x.Cmd); err != nil {
-			return false, x.meta, errors.Wrap(err, "couldn't exec command")
-		}
-		return true, x.meta, nil
+
+func (x *Execution) applyScript(ctx context.Context) (bool, string, error) {
+	file, err := os.CreateTemp("", "furnish-script-*")
+	if err != nil {
+		return false, x.meta, errors.Wrap(err, "couldn't create script file")
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(x.Script)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0o700)
+	}
+	if err != nil {
+		return false, x.meta, errors.Wrap(err, "couldn't write script file")
+	}
+
+	c := x.command()
+	if len(c.Interpreter) == 0 && strings.HasPrefix(x.Script, "#!") {
+		err = c.Executable(ctx, file.Name(), x.Args)
+	} else {
+		err = c.Script(ctx, file.Name(), x.Args)
+	}
+	if err != nil {
+		return false, x.meta, errors.Wrap(err, "couldn't exec script")
 	}
-	if err := Exec(x.Cmd); err != nil {
+	return true, x.meta, nil
+}
+
+// verify checks the content of the file against the pinned sha256.
+func (x *Execution) verify() error {
+	if x.SHA256 == "" {
+		return nil
+	}
+	content, err := os.ReadFile(x.File)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't read script '%s'", x.File)
+	}
+	sum := sha256.Sum256(content)
+	if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, x.SHA256) {
+		return errors.Errorf("script '%s' changed, its sha256 is %s instead of %s", x.File, actual, x.SHA256)
+	}
+	return nil
+}
+
+func (x *Execution) applyCmd(ctx context.Context) (bool, string, error) {
+	if err := x.command().Exec(ctx, x.Cmd); err != nil {
 		return false, x.meta, errors.Wrap(err, "couldn't exec command")
 	}
 	return true, x.meta, nil
 }
 
+func (x *Execution) command() *Command {
+	c := &Command{Interpreter: x.Interpreter, Env: x.Env, Dir: x.Dir, Silent: x.Silent}
+	if x.Shell != "" {
+		c.Interpreter = Interpreter{x.Shell}
+	}
+	return c
+}
+
 func (x *Execution) setMeta() {
-	if x.File != "" {
+	switch {
+	case x.File != "":
 		x.meta = fmt.Sprintf("mode: file; silent: %t; path: '%s'", x.Silent, x.File)
-		return
+		if x.SHA256 != "" {
+			x.meta += "; sha256: pinned"
+		}
+	case x.Script != "":
+		lines := strings.Count(strings.TrimRight(x.Script, "\n"), "\n") + 1
+		x.meta = fmt.Sprintf("mode: script; silent: %t; lines: %d", x.Silent, lines)
+	default:
+		x.meta = fmt.Sprintf("mode: cmd; silent: %t; cmd: '%s'", x.Silent, x.Cmd)
+	}
+	if interpreter := x.command().Interpreter; len(interpreter) > 0 {
+		x.meta += fmt.Sprintf("; interpreter: '%s'", strings.Join(interpreter, " "))
+	}
+	if x.Dir != "" {
+		x.meta += fmt.Sprintf("; dir: '%s'", x.Dir)
 	}
-	x.meta = fmt.Sprintf("mode: cmd; silent: %t; cmd: '%s'", x.Silent, x.Cmd)
 }
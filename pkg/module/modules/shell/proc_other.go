@@ -0,0 +1,10 @@
+//go:build !unix
+
+package shell
+
+import "os/exec"
+
+// setProcessGroup is a no-op where process groups aren't available.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func kill(cmd *exec.Cmd, group bool) { _ = cmd.Process.Kill() }
@@ -3,13 +3,39 @@ package ssh
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
 	"github.com/tenderly/furnish/pkg/module"
 	"github.com/tenderly/furnish/pkg/module/modules/shell"
 )
 
-var _ module.Module = (*SSH)(nil)
+const Kind module.Kind = "ssh"
+
+// defaultKeyType is the type ssh-keygen generates when none is given.
+const defaultKeyType = "ed25519"
+
+func init() { module.Register(Kind, decode) }
+
+func decode(node *yaml.Node) (module.Modules, error) {
+	s := &SSH{}
+	if err := node.Decode(s); err != nil {
+		return nil, err
+	}
+	if !s.Enabled {
+		return nil, nil
+	}
+	return module.Modules{s}, nil
+}
+
+var (
+	_ module.Module      = (*SSH)(nil)
+	_ module.Interactive = (*SSH)(nil)
+)
 
 type SSH struct {
 	module.BaseDependable `yaml:",inline"`
@@ -32,6 +58,9 @@ func (s *SSH) IsMandatory() bool {
 	return s.Enabled && s.Mandatory
 }
 
+// IsInteractive is true since ssh-keygen asks for the passphrase when none is given.
+func (s *SSH) IsInteractive() bool { return s.Passphrase == "" }
+
 func (s *SSH) build() string {
 	cmd := "ssh-keygen"
 	if s.Output != "" {
@@ -51,7 +80,15 @@ func (s *SSH) build() string {
 }
 
 func (s *SSH) Apply(ctx context.Context) (bool, string, error) {
-	err := shell.Exec(s.build())
+	exists, err := s.keyExists()
+	if err != nil {
+		return false, "generate", err
+	}
+	if exists {
+		return false, "generate", nil
+	}
+
+	err = shell.Exec(ctx, s.build())
 	if err != nil {
 		return false, "generate", errors.Wrap(err, "failed generating ssh key")
 	}
@@ -59,6 +96,51 @@ func (s *SSH) Apply(ctx context.Context) (bool, string, error) {
 	return true, "generate", nil
 }
 
+func (s *SSH) Check(ctx context.Context) (module.Action, string, error) {
+	exists, err := s.keyExists()
+	if err != nil {
+		return module.ActionSkip, "generate", err
+	}
+	if exists {
+		return module.ActionSkip, "generate", nil
+	}
+	return module.ActionRun, "generate", nil
+}
+
+func (s *SSH) keyExists() (bool, error) {
+	path, err := s.keyPath()
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed checking the ssh key")
+	}
+	return true, nil
+}
+
+// keyPath is the private key ssh-keygen writes, ~/.ssh/id_<type> unless the output is set.
+func (s *SSH) keyPath() (string, error) {
+	path := s.Output
+	if path == "" {
+		keyType := s.Type
+		if keyType == "" {
+			keyType = defaultKeyType
+		}
+		path = filepath.Join("~", ".ssh", "id_"+strings.ReplaceAll(keyType, "-", "_"))
+	}
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed resolving the ssh key path")
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
 func (s *SSH) GetID() module.ID {
 	return "ssh"
 }
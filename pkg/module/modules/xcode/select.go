@@ -7,10 +7,30 @@ import (
 	"github.com/tenderly/furnish/pkg/module/modules/shell"
 
 	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 
 	"github.com/tenderly/furnish/pkg/module"
 )
 
+const Kind module.Kind = "xcode-select"
+
+func init() { module.Register(Kind, decode) }
+
+func decode(node *yaml.Node) (module.Modules, error) {
+	x := &XCodeSelect{}
+	if err := node.Decode(x); err != nil {
+		return nil, err
+	}
+	if !x.Enabled {
+		return nil, nil
+	}
+	// xcode-select only exists on macOS, other systems skip it unless the declaration says otherwise.
+	if x.When == "" {
+		x.When = `os == "darwin"`
+	}
+	return module.Modules{x}, nil
+}
+
 type Declaration struct {
 	XCodeSelect bool `yaml:"xcode-select"`
 }
@@ -30,27 +50,45 @@ func (_ *XCodeSelect) IsOptional() bool { return false }
 func (x *XCodeSelect) IsMandatory() bool { return x.Mandatory }
 
 func (x *XCodeSelect) Apply(ctx context.Context) (bool, string, error) {
-	output, err := shell.ExecOutput("arch -arm64 xcode-select -p")
+	installed, err := x.installed(ctx)
 	if err != nil {
-		return false, "exists", errors.Wrap(err, "failed checking if xcode-select is installed")
+		return false, "exists", err
 	}
-	if strings.Contains(strings.ToLower(output), "developer") {
+	if installed {
 		return false, "install", nil
 	}
-	if err := shell.Exec("arch -arm64 xcode-select --install"); err != nil {
+	if err := shell.Exec(ctx, "xcode-select --install"); err != nil {
 		return false, "install", errors.Wrap(err, "failed installing xcode-select")
 	}
 	return true, "install", nil
 }
 
+func (x *XCodeSelect) Check(ctx context.Context) (module.Action, string, error) {
+	installed, err := x.installed(ctx)
+	if err != nil {
+		return module.ActionSkip, "exists", err
+	}
+	if installed {
+		return module.ActionSkip, "install", nil
+	}
+	return module.ActionInstall, "install", nil
+}
+
+func (x *XCodeSelect) installed(ctx context.Context) (bool, error) {
+	output, err := shell.ExecOutput(ctx, "xcode-select -p")
+	if err != nil {
+		return false, errors.Wrap(err, "failed checking if xcode-select is installed")
+	}
+	return strings.Contains(strings.ToLower(output), "developer"), nil
+}
+
 func (x *XCodeSelect) GetVersion() module.Version {
-	output, err := shell.ExecOutput("xcode-select --version | cut -f3 -d' '")
+	output, err := shell.ExecOutput(context.Background(), "xcode-select --version | cut -f3 -d' '")
 	if err != nil {
 		return x.Version
 	}
 	output = strings.Replace(output, "\n", "", 1)
-	x.Version = module.Version(strings.Replace(output, ".", "", 1))
-	return x.Version
+	return module.Version(strings.Replace(output, ".", "", 1))
 }
 
 func (x *XCodeSelect) GetID() module.ID { return "xcode-select" }
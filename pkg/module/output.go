@@ -0,0 +1,32 @@
+package module
+
+import (
+	"context"
+	"io"
+)
+
+// Interactive is implemented by modules which may read from the terminal, e.g. to ask for a password.
+// When modules are applied in parallel they are applied alone, with the terminal to themselves.
+type Interactive interface {
+	IsInteractive() bool
+}
+
+func isInteractive(m Module) bool {
+	i, ok := m.(Interactive)
+	return ok && i.IsInteractive()
+}
+
+type outputKey struct{}
+
+// WithOutput returns a context whose commands write their output to w instead of the terminal and
+// don't read from it. The output of modules applied in parallel is buffered this way and printed
+// together with the outcome of the module.
+func WithOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, w)
+}
+
+// Output returns the writer the commands of the context write to, nil if they use the terminal.
+func Output(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(outputKey{}).(io.Writer)
+	return w
+}
@@ -0,0 +1,134 @@
+package module
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatih/color"
+)
+
+// Action is what applying a module would do to the machine.
+type Action string
+
+const (
+	ActionInstall Action = "install"
+	ActionUpdate  Action = "update"
+	ActionDelete  Action = "delete"
+	ActionRun     Action = "run"
+	ActionSkip    Action = "skip"
+)
+
+var planSymbols = map[Action]string{
+	ActionInstall: color.GreenString("+"),
+	ActionUpdate:  color.YellowString("~"),
+	ActionDelete:  color.RedString("-"),
+	ActionRun:     color.CyanString(">"),
+	ActionSkip:    color.WhiteString("="),
+}
+
+var (
+	fmtPlanModule = "  %s %s %s" + color.WhiteString("\n\tmeta: [%s]\n")
+	fmtPlanError  = color.RedString("  ! %s check failed.") + color.WhiteString("\n\tmeta: [%s]\n") +
+		color.RedString("  err: %s\n")
+)
+
+type ModulePlan struct {
+	ID     ID     `json:"id"`
+	Action Action `json:"action"`
+	Meta   string `json:"meta"`
+	Err    error  `json:"-"`
+}
+
+type StagePlan struct {
+	ID      ID            `json:"id"`
+	Modules []*ModulePlan `json:"modules"`
+}
+
+// Plan is the outcome of checking every module in the order apply would run them.
+type Plan []*StagePlan
+
+// Plan checks every module without applying it. The stages and modules are sorted
+// the same way Apply sorts them, modules whose condition is false are skipped without checking.
+func (s Stages) Plan(ctx context.Context, cfg *ApplyConfig) (Plan, error) {
+	applier, err := newStagesApplier(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make(Plan, 0, len(applier.stages))
+	for _, stage := range applier.stages {
+		stagePlan := &StagePlan{ID: stage.GetID()}
+		for _, m := range applier.modules[stage.GetID()] {
+			if when, ok := applier.unmet[Qualify(stage.GetID(), m.GetID())]; ok {
+				stagePlan.Modules = append(stagePlan.Modules, &ModulePlan{
+					ID:     m.GetID(),
+					Action: ActionSkip,
+					Meta:   "condition: " + when,
+				})
+				continue
+			}
+			action, meta, err := m.Check(ctx)
+			stagePlan.Modules = append(stagePlan.Modules, &ModulePlan{
+				ID:     m.GetID(),
+				Action: action,
+				Meta:   meta,
+				Err:    err,
+			})
+		}
+		plan = append(plan, stagePlan)
+	}
+	return plan, nil
+}
+
+// Count returns the number of modules that would be affected by the action.
+func (p Plan) Count(action Action) int {
+	count := 0
+	for _, s := range p {
+		for _, m := range s.Modules {
+			if m.Err == nil && m.Action == action {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Errors returns the number of modules which couldn't be checked.
+func (p Plan) Errors() int {
+	count := 0
+	for _, s := range p {
+		for _, m := range s.Modules {
+			if m.Err != nil {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Print writes the plan in a diff like format.
+func (p Plan) Print() {
+	for _, s := range p {
+		color.Blue("[%s]", s.ID)
+		if len(s.Modules) == 0 {
+			color.Yellow("stage '%s' empty, skipping", s.ID)
+			continue
+		}
+		for _, m := range s.Modules {
+			if m.Err != nil {
+				fmt.Printf(fmtPlanError, m.ID, m.Meta, m.Err.Error())
+				continue
+			}
+			fmt.Printf(fmtPlanModule, planSymbols[m.Action], m.Action, m.ID, m.Meta)
+		}
+		color.White("\n")
+	}
+
+	color.White(
+		"plan: %d to install, %d to update, %d to delete, %d to run, %d unchanged",
+		p.Count(ActionInstall), p.Count(ActionUpdate), p.Count(ActionDelete), p.Count(ActionRun), p.Count(ActionSkip),
+	)
+	if errs := p.Errors(); errs > 0 {
+		color.Red("[✘] couldn't check %d modules", errs)
+	}
+}
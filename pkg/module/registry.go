@@ -0,0 +1,144 @@
+package module
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Kind is the key under which a module type is declared in a stage.
+type Kind string
+
+func (k Kind) String() string { return string(k) }
+
+// Decoder decodes the yaml node declared under a kind key into modules.
+// Modules which are declared but disabled shouldn't be returned.
+type Decoder func(node *yaml.Node) (Modules, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[Kind]Decoder)
+)
+
+// Register makes a module kind available in stage declarations. Module packages register their kinds
+// in init, custom kinds can be registered from a custom main before the config is loaded.
+// It panics if the kind is registered twice or if it clashes with a key of the stage itself.
+func Register(kind Kind, decode Decoder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if decode == nil {
+		panic(fmt.Sprintf("module: decoder for kind '%s' is nil", kind))
+	}
+	if IsBaseKey(kind.String()) {
+		panic(fmt.Sprintf("module: kind '%s' clashes with a stage field", kind))
+	}
+	if _, ok := registry[kind]; ok {
+		panic(fmt.Sprintf("module: kind '%s' registered twice", kind))
+	}
+	registry[kind] = decode
+}
+
+// Lookup returns the decoder registered for the kind.
+func Lookup(kind Kind) (Decoder, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	decode, ok := registry[kind]
+	return decode, ok
+}
+
+// Kinds returns all registered kinds sorted by name.
+func Kinds() []Kind {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	kinds := make([]Kind, 0, len(registry))
+	for kind := range registry {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+	return kinds
+}
+
+// DecodeSequence decodes every item of a sequence node into a module and records the line of the item.
+func DecodeSequence(node *yaml.Node, decode func(item *yaml.Node) (Module, error)) (Modules, error) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil, nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: expected a list", node.Line)
+	}
+	modules := make(Modules, 0, len(node.Content))
+	for _, item := range node.Content {
+		m, err := decode(item)
+		if err != nil {
+			return nil, err
+		}
+		m.SetSource(Source{Line: item.Line})
+		modules = append(modules, m)
+	}
+	return modules, nil
+}
+
+// UnknownKeys returns the key nodes of the mapping which aren't yaml fields of v, e.g. misspelled options.
+func UnknownKeys(node *yaml.Node, v interface{}) []*yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	known := yamlKeys(t)
+	unknown := make([]*yaml.Node, 0)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if _, ok := known[node.Content[i].Value]; !ok {
+			unknown = append(unknown, node.Content[i])
+		}
+	}
+	return unknown
+}
+
+var baseKeys = yamlKeys(reflect.TypeOf(BaseDependable{}))
+
+// IsBaseKey reports whether the key is a yaml field of BaseDependable.
+func IsBaseKey(key string) bool {
+	_, ok := baseKeys[key]
+	return ok
+}
+
+// yamlKeys returns the yaml keys of the struct fields, including the inlined ones.
+func yamlKeys(t reflect.Type) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("yaml")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") && field.Type.Kind() == reflect.Struct {
+			for key := range yamlKeys(field.Type) {
+				keys[key] = struct{}{}
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		keys[name] = struct{}{}
+	}
+	return keys
+}
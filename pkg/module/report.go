@@ -0,0 +1,97 @@
+package module
+
+import (
+	"time"
+)
+
+type ModuleStatus string
+
+const (
+	StatusApplied  ModuleStatus = "applied"
+	StatusSkipped  ModuleStatus = "skipped"
+	StatusFailed   ModuleStatus = "failed"
+	StatusDeclined ModuleStatus = "declined"
+	// StatusDependencyFailed is set on modules which weren't applied because one of their dependencies failed.
+	StatusDependencyFailed ModuleStatus = "dependency-failed"
+	// StatusConditionUnmet is set on modules whose when condition, or the one of their stage, is false.
+	StatusConditionUnmet ModuleStatus = "skipped-condition"
+	// StatusTimedOut is set on modules which were killed because they ran longer than their timeout.
+	StatusTimedOut ModuleStatus = "timed-out"
+	// StatusInterrupted is set on modules which were stopped because the run was interrupted.
+	StatusInterrupted ModuleStatus = "interrupted"
+	// StatusNotStarted is set on modules which the run stopped before, e.g. after a mandatory module failed.
+	StatusNotStarted ModuleStatus = "not-started"
+)
+
+// Failed reports whether the module failed, timing out and being interrupted included.
+func (ms ModuleStatus) Failed() bool {
+	return ms == StatusFailed || ms == StatusTimedOut || ms == StatusInterrupted
+}
+
+// Skipped reports whether the module wasn't applied without failing itself.
+func (ms ModuleStatus) Skipped() bool {
+	return ms == StatusSkipped || ms == StatusDeclined || ms == StatusDependencyFailed ||
+		ms == StatusConditionUnmet || ms == StatusNotStarted
+}
+
+// ModuleReport is the outcome of a single module in a run.
+type ModuleReport struct {
+	ID       ID            `json:"id"`
+	Stage    ID            `json:"stage"`
+	Status   ModuleStatus  `json:"status"`
+	Meta     string        `json:"meta"`
+	Error    string        `json:"error,omitempty"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	// Hash is the digest of the declared module configuration, taken before the run changed anything.
+	Hash string `json:"hash,omitempty"`
+	// Attempts are recorded for the modules which can be retried, Started and Duration span all of them.
+	Attempts []*AttemptReport `json:"attempts,omitempty"`
+}
+
+// AttemptReport is a single attempt of applying a module.
+type AttemptReport struct {
+	Attempt  int           `json:"attempt"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+	TimedOut bool          `json:"timed_out,omitempty"`
+}
+
+// StageReport contains the module reports of a stage in the order they finished.
+type StageReport struct {
+	ID       ID              `json:"id"`
+	Modules  []*ModuleReport `json:"modules"`
+	Started  time.Time       `json:"started"`
+	Duration time.Duration   `json:"duration_ns"`
+}
+
+// Count returns the number of modules in the stage with the status.
+func (sr *StageReport) Count(status ModuleStatus) int {
+	count := 0
+	for _, m := range sr.Modules {
+		if m.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// RunReport is the structured record of a whole run. It's returned even if the run fails,
+// in which case it contains the stages applied before the failure.
+type RunReport struct {
+	Manager  string         `json:"manager"`
+	Error    string         `json:"error,omitempty"`
+	Started  time.Time      `json:"started"`
+	Duration time.Duration  `json:"duration_ns"`
+	Stages   []*StageReport `json:"stages"`
+}
+
+// Count returns the number of modules in all stages with the status.
+func (rr *RunReport) Count(status ModuleStatus) int {
+	count := 0
+	for _, s := range rr.Stages {
+		count += s.Count(status)
+	}
+	return count
+}
@@ -0,0 +1,101 @@
+package module
+
+import (
+	"strings"
+
+	"github.com/fatih/color"
+
+	"github.com/tenderly/furnish/pkg/util"
+)
+
+// selectOptional asks once, before anything is applied, which of the optional modules should be applied,
+// so the run doesn't need attention afterwards. Declining a module declines its dependants as well.
+func (dma *stagesApplier) selectOptional() {
+	options := make([]util.Option, 0)
+	optional := make(map[ID]Module)
+	for _, s := range dma.stages {
+		for _, m := range dma.modules[s.GetID()] {
+			key := Qualify(s.GetID(), m.GetID())
+			if _, unmet := dma.unmet[key]; unmet {
+				continue
+			}
+			if !m.IsOptional() || (dma.resumer != nil && dma.resumer.Succeeded(m)) {
+				continue
+			}
+			optional[key] = m
+			options = append(options, util.Option{
+				Key:         key.String(),
+				Name:        m.GetID().String(),
+				Description: m.GetDescription(),
+			})
+		}
+	}
+	if len(options) == 0 {
+		return
+	}
+
+	selected := make(map[ID]struct{})
+	for _, key := range util.Select("The following modules are optional.", options) {
+		selected[ID(key)] = struct{}{}
+	}
+
+	for key := range optional {
+		if _, ok := selected[key]; ok {
+			continue
+		}
+		dma.declined[key] = struct{}{}
+	}
+	for _, o := range options {
+		key := ID(o.Key)
+		if _, ok := dma.declined[key]; !ok {
+			continue
+		}
+		dependants := dma.declineDependants(optional[key].GetParent(), optional[key])
+		if len(dependants) > 0 {
+			color.Yellow("[warn] declining '%s' also declines its dependants: %s", key, strings.Join(dependants, ", "))
+		}
+	}
+	color.White("\n")
+}
+
+// declineDependants declines all modules which directly or transitively depend on the module,
+// in its stage and in the later ones, and returns the ones which weren't declined before.
+func (dma *stagesApplier) declineDependants(stage ID, m Module) []string {
+	type queued struct {
+		stage ID
+		id    ID
+	}
+	enqueue := func(queue []queued, stage ID, m Module) []queued {
+		for _, d := range m.GetDependants() {
+			queue = append(queue, queued{stage: stage, id: d})
+		}
+		return queue
+	}
+
+	declined := make([]string, 0)
+	queue := enqueue(nil, stage, m)
+	for len(queue) > 0 {
+		q := queue[0]
+		queue = queue[1:]
+
+		// Dependants in other stages are recorded with their qualified IDs.
+		stage, id := q.stage, q.id
+		dependant, ok := dma.modules[stage].Map()[id]
+		if !ok {
+			if stage, id, ok = id.Split(); ok {
+				dependant, ok = dma.modules[stage].Map()[id]
+			}
+		}
+		if !ok {
+			continue
+		}
+		key := Qualify(stage, id)
+		if _, ok := dma.declined[key]; ok {
+			continue
+		}
+		dma.declined[key] = struct{}{}
+		declined = append(declined, key.String())
+		queue = enqueue(queue, stage, dependant)
+	}
+	return declined
+}
@@ -0,0 +1,24 @@
+package module
+
+import (
+	"fmt"
+)
+
+// Source is the place in the configuration where a dependable is declared.
+type Source struct {
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+func (s Source) String() string {
+	if s.Line == 0 {
+		return s.File
+	}
+	return fmt.Sprintf("%s:%d", s.File, s.Line)
+}
+
+// PathResolver is implemented by modules which refer to files. Relative paths are resolved against
+// the directory of the file which declared the module, not the working directory.
+type PathResolver interface {
+	ResolvePaths(dir string)
+}
@@ -1,12 +1,12 @@
 package module
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os"
-
-	"github.com/tenderly/furnish/pkg/util"
+	"time"
 
 	"github.com/fatih/color"
 
@@ -23,26 +23,127 @@ var (
 	) + color.RedString(
 		"  err: %s\n",
 	)
-	fmtSkip    = color.YellowString("  [%d/%d] '%s' skipped - already applied") + color.WhiteString("\n\tmeta: [%s]\n")
-	fmtSuccess = color.GreenString("  [%d/%d] '%s' applied.") + color.WhiteString("\n\tmeta: [%s]\n")
+	fmtSkip        = color.YellowString("  [%d/%d] '%s' skipped - already applied") + color.WhiteString("\n\tmeta: [%s]\n")
+	fmtSkipDecline = color.YellowString("  [%d/%d] '%s' skipped - declined\n")
+	fmtSkipDep     = color.YellowString("  [%d/%d] '%s' skipped - dependency '%s' failed\n")
+	fmtSkipResume  = color.YellowString("  [%d/%d] '%s' skipped - succeeded in the resumed run\n")
+	fmtSkipUnmet   = color.YellowString("  [%d/%d] '%s' skipped (condition)") + color.WhiteString("\n\twhen: [%s]\n")
+	fmtSuccess     = color.GreenString("  [%d/%d] '%s' applied.") + color.WhiteString("\n\tmeta: [%s]\n")
+	fmtRetry       = color.YellowString("  '%s' attempt %d/%d failed, retrying in %s") + color.RedString("\n  err: %s\n")
+	fmtGaveUp      = color.RedString("  '%s' gave up after %d attempts\n")
+	fmtInterrupted = color.RedString("  [%d/%d] '%s' interrupted.") + color.WhiteString("\n\tmeta: [%s]\n")
+	fmtTimedOut    = color.RedString("  [%d/%d] '%s' timed out after %s.") + color.WhiteString("\n\tmeta: [%s]\n")
+)
+
+const (
+	// defaultRetryDelay is used when a module is retried without a delay.
+	defaultRetryDelay = 2 * time.Second
+	// maxRetryDelay caps the exponential backoff.
+	maxRetryDelay = 5 * time.Minute
+	// defaultGrace is how long the running modules may take to finish once the run is interrupted.
+	defaultGrace = 30 * time.Second
 )
 
+// ApplyConfig is used to configure how the stages are applied.
+type ApplyConfig struct {
+	// Concurrency is the maximum number of modules applied in parallel inside a stage.
+	// Modules are started only after all of their dependencies have been applied.
+	// Values lower than 1 apply the modules one at a time.
+	Concurrency int
+	// Manager is the name of the default package manager, it's recorded in the run report.
+	Manager string
+	// Recorders are notified after every module is done.
+	Recorders []Recorder
+	// Resumer skips the modules which succeeded in a previous run.
+	Resumer Resumer
+	// Conditions evaluates the when conditions of the stages and modules.
+	Conditions Evaluator
+	// Retries, RetryDelay and Timeout are used for the modules and stages which don't set their own.
+	Retries    int
+	RetryDelay time.Duration
+	Timeout    time.Duration
+	// Grace is how long the running modules may take to finish once the context is cancelled,
+	// before they are killed. No new modules are started. Values lower than 1 use the default.
+	// Commands reading from the terminal get the interrupt from the terminal and stop right away.
+	Grace time.Duration
+}
+
+// Recorder is notified about the outcome of every module, e.g. to persist it between runs.
+type Recorder interface {
+	Record(m Module, r *ModuleReport) error
+}
+
+// Resumer tells which modules succeeded in the run being resumed.
+type Resumer interface {
+	Succeeded(m Module) bool
+}
+
+// Evaluator evaluates when conditions.
+type Evaluator interface {
+	Evaluate(condition string) (bool, error)
+}
+
 type Stages []Stage
 
-func (s Stages) Apply(ctx context.Context) ([]bool, error) {
-	return newStagesApplier(s).ApplyMany(ctx)
+// Apply applies the stages in the order of their dependencies. The report is always returned,
+// if the run fails it contains the stages applied before the failure.
+func (s Stages) Apply(ctx context.Context, cfg *ApplyConfig) (*RunReport, error) {
+	report := &RunReport{Started: time.Now(), Stages: make([]*StageReport, 0, len(s))}
+	if cfg != nil {
+		report.Manager = cfg.Manager
+	}
+
+	applier, err := newStagesApplier(s, cfg)
+	if err == nil {
+		err = applier.ApplyMany(ctx, report)
+		applier.reportNotStarted(report)
+	}
+
+	report.Duration = time.Since(report.Started)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report, err
 }
 
 type stagesApplier struct {
 	stages Stages
+	// modules contains the sorted modules of every stage.
+	modules  map[ID]Modules
+	declined map[ID]struct{}
+	// unmet contains the modules whose condition is false, with the condition.
+	unmet map[ID]string
+	// done and broken contain the qualified IDs of the modules across stages, so dependants
+	// in later stages are gated on them as well. Broken modules failed or were skipped because
+	// one of their dependencies failed.
+	done, broken map[ID]struct{}
+	jobs         int
+	recorders    []Recorder
+	resumer      Resumer
+	// hashes contain the digests of the modules taken before they are applied, by their qualified IDs.
+	hashes map[ID]string
+	// retries, retryDelay and timeout are the global defaults.
+	retries    int
+	retryDelay time.Duration
+	timeout    time.Duration
+	grace      time.Duration
 }
 
-func newStagesApplier(s Stages) *stagesApplier {
+// Sorted returns the stages and the modules of every stage in the order apply runs them.
+// A module which depends on a module of another stage makes its stage depend on that stage.
+func (s Stages) Sorted() (Stages, map[ID]Modules, error) {
+	if err := s.linkStages(); err != nil {
+		return nil, nil, err
+	}
+
 	dependables := make(Dependables, 0, len(s))
 	for _, s := range s {
 		dependables = append(dependables, s.(Dependable))
 	}
-	dependables = dependables.Sort()
+	dependables, err := dependables.Sort()
+	if err != nil {
+		return nil, nil, err
+	}
 
 	sortedStages := make(Stages, 0, len(dependables))
 	for _, r := range dependables {
@@ -54,91 +155,617 @@ func newStagesApplier(s Stages) *stagesApplier {
 		sortedStages = append(sortedStages, stage)
 	}
 
-	return &stagesApplier{stages: sortedStages}
+	sortedModules := make(map[ID]Modules, len(sortedStages))
+	for _, stage := range sortedStages {
+		modules, err := sortModules(stage.Modules())
+		if err != nil {
+			return nil, nil, err
+		}
+		sortedModules[stage.GetID()] = modules
+	}
+	return sortedStages, sortedModules, nil
+}
+
+// linkStages resolves the qualified dependencies, stage/module, which don't match a module of the
+// dependant's own stage. The dependency learns about its dependant and the stages are ordered accordingly.
+func (s Stages) linkStages() error {
+	stageModules := make(map[ID]map[ID]Module, len(s))
+	for _, stage := range s {
+		stageModules[stage.GetID()] = stage.Modules().Map()
+	}
+
+	for _, stage := range s {
+		local := stageModules[stage.GetID()]
+		for _, m := range stage.Modules() {
+			for _, d := range m.GetDependencies() {
+				if _, ok := local[d]; ok {
+					continue
+				}
+				depStage, depID, qualified := d.Split()
+				if !qualified {
+					continue // Sort reports it as missing.
+				}
+				dependency, ok := stageModules[depStage][depID]
+				if !ok {
+					return &ErrMissingDependency{Stage: stage.GetID(), Module: m.GetID(), Dependency: d}
+				}
+				if depStage == stage.GetID() {
+					return fmt.Errorf(
+						"module '%s' in stage '%s' refers to '%s' of its own stage, use '%s'",
+						m.GetID(), stage.GetID(), d, depID,
+					)
+				}
+
+				dependency.AddDependants(Qualify(stage.GetID(), m.GetID()))
+				if _, ok := stage.GetDependencies().Unique()[depStage]; !ok {
+					stage.AddDependencies(depStage)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func newStagesApplier(s Stages, cfg *ApplyConfig) (*stagesApplier, error) {
+	sortedStages, sortedModules, err := s.Sorted()
+	if err != nil {
+		return nil, err
+	}
+
+	applier := &stagesApplier{
+		stages:   sortedStages,
+		modules:  sortedModules,
+		declined: make(map[ID]struct{}),
+		unmet:    make(map[ID]string),
+		done:     make(map[ID]struct{}),
+		broken:   make(map[ID]struct{}),
+		jobs:     1,
+		grace:    defaultGrace,
+		hashes:   make(map[ID]string),
+	}
+	for stage, modules := range sortedModules {
+		for _, m := range modules {
+			applier.hashes[Qualify(stage, m.GetID())] = Hash(m)
+		}
+	}
+	var conditions Evaluator
+	if cfg != nil {
+		if cfg.Concurrency > 1 {
+			applier.jobs = cfg.Concurrency
+		}
+		applier.recorders = cfg.Recorders
+		applier.resumer = cfg.Resumer
+		applier.retries, applier.retryDelay, applier.timeout = cfg.Retries, cfg.RetryDelay, cfg.Timeout
+		if cfg.Grace > 0 {
+			applier.grace = cfg.Grace
+		}
+		conditions = cfg.Conditions
+	}
+	if err := applier.evaluateConditions(conditions); err != nil {
+		return nil, err
+	}
+
+	return applier, nil
 }
 
-func (dma *stagesApplier) ApplyMany(ctx context.Context) ([]bool, error) {
+// evaluateConditions evaluates the conditions before anything is scheduled, so a broken condition fails
+// the run up front. The modules of a stage whose condition is false are all unmet.
+func (dma *stagesApplier) evaluateConditions(conditions Evaluator) error {
+	evaluate := func(d Dependable) (bool, error) {
+		if d.GetWhen() == "" {
+			return true, nil
+		}
+		if conditions == nil {
+			return false, fmt.Errorf("%s: '%s' has a condition but no facts to evaluate it", d.GetSource(), d.GetID())
+		}
+		ok, err := conditions.Evaluate(d.GetWhen())
+		if err != nil {
+			return false, fmt.Errorf("%s: '%s': %w", d.GetSource(), d.GetID(), err)
+		}
+		return ok, nil
+	}
+
+	for _, s := range dma.stages {
+		ok, err := evaluate(s)
+		if err != nil {
+			return err
+		}
+		for _, m := range dma.modules[s.GetID()] {
+			key := Qualify(s.GetID(), m.GetID())
+			if !ok {
+				dma.unmet[key] = s.GetWhen()
+				continue
+			}
+			met, err := evaluate(m)
+			if err != nil {
+				return err
+			}
+			if !met {
+				dma.unmet[key] = m.GetWhen()
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyMany applies the sorted stages and adds a stage report to the run report for every applied stage.
+// Once the context is cancelled no new modules are started, the running ones get the grace period
+// to finish before their context is cancelled as well.
+func (dma *stagesApplier) ApplyMany(ctx context.Context, report *RunReport) error {
+	dma.selectOptional()
+
+	moduleCtx, cancel := withGrace(ctx, dma.grace)
+	defer cancel()
+
 	for _, s := range dma.stages {
-		modules := s.Modules()
+		if err := ctx.Err(); err != nil {
+			return &ErrInterrupted{Stage: s.GetID(), Err: err}
+		}
+		modules := dma.modules[s.GetID()]
 		if len(modules) == 0 {
 			color.Yellow("stage '%s' empty, skipping", s.GetID())
 			continue
 		}
 
-		dependables := make(Dependables, 0, len(modules))
-		for _, m := range modules {
-			dependables = append(dependables, m.(Dependable))
+		stageReport, err := dma.applyMany(ctx, moduleCtx, s, modules)
+		if stageReport != nil {
+			report.Stages = append(report.Stages, stageReport)
 		}
-		dependables = dependables.Sort()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		sorted := make(Modules, 0, len(dependables))
-		for _, r := range dependables {
-			module, ok := r.(Module)
-			if !ok {
-				log.Info("relator not a module")
+// reportNotStarted adds the modules the run stopped before to the report, so it accounts for every
+// module. They aren't recorded, the recorders keep their previous outcome.
+func (dma *stagesApplier) reportNotStarted(report *RunReport) {
+	stageReports := make(map[ID]*StageReport, len(report.Stages))
+	for _, sr := range report.Stages {
+		stageReports[sr.ID] = sr
+	}
+
+	for _, s := range dma.stages {
+		stageReport, ok := stageReports[s.GetID()]
+		if !ok {
+			stageReport = &StageReport{ID: s.GetID()}
+		}
+		reported := make(map[ID]struct{}, len(stageReport.Modules))
+		for _, m := range stageReport.Modules {
+			reported[m.ID] = struct{}{}
+		}
+
+		notStarted := 0
+		for _, m := range dma.modules[s.GetID()] {
+			if _, ok := reported[m.GetID()]; ok {
 				continue
 			}
-			sorted = append(sorted, module)
+			stageReport.Modules = append(stageReport.Modules, &ModuleReport{
+				ID:     m.GetID(),
+				Stage:  s.GetID(),
+				Status: StatusNotStarted,
+				Hash:   dma.hashes[Qualify(s.GetID(), m.GetID())],
+			})
+			notStarted++
+		}
+		if !ok && notStarted > 0 {
+			report.Stages = append(report.Stages, stageReport)
+		}
+	}
+}
+
+// withGrace returns a context which is cancelled the grace period after the parent is done.
+func withGrace(parent context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// sortModules orders the modules of a single stage so that every module comes after its dependencies.
+func sortModules(modules Modules) (Modules, error) {
+	dependables := make(Dependables, 0, len(modules))
+	for _, m := range modules {
+		dependables = append(dependables, m.(Dependable))
+	}
+	dependables, err := dependables.Sort()
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make(Modules, 0, len(dependables))
+	for _, r := range dependables {
+		module, ok := r.(Module)
+		if !ok {
+			log.Info("relator not a module")
+			continue
+		}
+		sorted = append(sorted, module)
+	}
+	return sorted, nil
+}
+
+// moduleOutcome is the result of a module apply, sent from the workers to the scheduler. A failed attempt
+// which is retried isn't final, the module is still running.
+type moduleOutcome struct {
+	module   Module
+	ok       bool
+	meta     string
+	err      error
+	started  time.Time
+	duration time.Duration
+	final    bool
+	timedOut bool
+	// interrupted is set when the module failed after the run was interrupted.
+	interrupted bool
+	// attempt is the number of the failed attempt and retryIn the delay before the next one.
+	attempt, retries int
+	retryIn          time.Duration
+	// attempts are set on the final outcome of a module which can be retried.
+	attempts []*AttemptReport
+}
+
+// applyPolicy is how a module is applied: how many times it's retried, the delay before the first retry
+// and the timeout of every attempt, zero for none.
+type applyPolicy struct {
+	retries int
+	delay   time.Duration
+	timeout time.Duration
+}
+
+// policy returns the apply policy of the module. The module's settings take precedence over the stage's
+// and those over the global ones.
+func (dma *stagesApplier) policy(stage Dependable, m Module) applyPolicy {
+	p := applyPolicy{retries: dma.retries, delay: dma.retryDelay, timeout: dma.timeout}
+	for _, d := range []Dependable{stage, m} {
+		if r, ok := d.GetRetries(); ok {
+			p.retries = r
+		}
+		if rd, ok := d.GetRetryDelay(); ok {
+			p.delay = rd
+		}
+		if t, ok := d.GetTimeout(); ok {
+			p.timeout = t
+		}
+	}
+	if p.delay <= 0 {
+		p.delay = defaultRetryDelay
+	}
+	return p
+}
+
+// applyOnce applies the module once, it's killed when it runs longer than the timeout.
+func applyOnce(ctx context.Context, m Module, timeout time.Duration) (bool, string, error) {
+	if timeout <= 0 {
+		return m.Apply(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ok, meta, err := m.Apply(attemptCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return ok, meta, &ErrTimedOut{Timeout: timeout, Err: err}
+	}
+	return ok, meta, err
+}
+
+// backoff returns the delay before the retry following the failed attempt, doubled after every attempt.
+func backoff(delay time.Duration, attempt int) time.Duration {
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// applyWithRetries applies the module with the module context until it succeeds or runs out of retries.
+// Every failed attempt which is retried is sent as a non-final outcome, the last one is final.
+// Nothing is retried once the run context is done.
+func applyWithRetries(ctx, moduleCtx context.Context, m Module, p applyPolicy, outcomes chan<- *moduleOutcome) {
+	first := time.Now()
+	attempts := make([]*AttemptReport, 0, p.retries+1)
+	for attempt := 1; ; attempt++ {
+		started := time.Now()
+		ok, meta, err := applyOnce(moduleCtx, m, p.timeout)
+		duration := time.Since(started)
+		var timeoutErr *ErrTimedOut
+		timedOut := errors.As(err, &timeoutErr)
+		if p.retries > 0 {
+			report := &AttemptReport{Attempt: attempt, Started: started, Duration: duration, TimedOut: timedOut}
+			if err != nil {
+				report.Error = err.Error()
+			}
+			attempts = append(attempts, report)
 		}
 
-		if _, err := dma.applyMany(ctx, s.GetID(), sorted); err != nil {
-			return nil, err
+		if err != nil && attempt <= p.retries && ctx.Err() == nil {
+			wait := backoff(p.delay, attempt)
+			outcomes <- &moduleOutcome{
+				module: m, meta: meta, err: err, attempt: attempt, retries: p.retries, retryIn: wait,
+			}
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+			}
+		}
+		outcomes <- &moduleOutcome{
+			module: m, ok: ok, meta: meta, err: err, started: first, duration: time.Since(first),
+			final: true, timedOut: timedOut, interrupted: err != nil && ctx.Err() != nil, attempts: attempts,
 		}
+		return
 	}
-	return nil, nil
 }
 
-func (dma *stagesApplier) applyMany(ctx context.Context, stage ID, modules Modules) ([]bool, error) {
+// printOutput prints the buffered command output of a module, ending it with a new line.
+func printOutput(output *bytes.Buffer) {
+	if output.Len() == 0 {
+		return
+	}
+	if !bytes.HasSuffix(output.Bytes(), []byte("\n")) {
+		output.WriteByte('\n')
+	}
+	_, _ = os.Stdout.Write(output.Bytes())
+}
+
+// applyMany applies the sorted modules of a stage. Modules whose dependencies are all done are applied
+// in parallel, up to the configured number of jobs. All the output is printed from the scheduling
+// goroutine so the lines of different modules never interleave, the output of the commands run by a
+// parallel module is buffered and printed with its outcome. Interactive modules are applied alone
+// and keep the terminal. A module which fails is retried
+// according to its retry policy, it's treated as failed only after the last attempt. Once the context
+// is done no new modules are started and the stage returns after the running ones.
+func (dma *stagesApplier) applyMany(ctx, moduleCtx context.Context, s Stage, modules Modules) (*StageReport, error) {
 	if dma == nil {
 		return nil, errors.New("no packages found")
 	}
 
+	stage := s.GetID()
 	color.Blue("[%s]", stage)
-	failed, skipped, applied := make(map[ID]error), make(IDs, 0), make(IDs, 0)
+	report := &StageReport{ID: stage, Modules: make([]*ModuleReport, 0, len(modules)), Started: time.Now()}
 	total := len(modules)
 
-	for i, m := range modules {
-		if m.IsOptional() {
-			if !util.ReadConfirmation(
-				fmt.Sprintf("Module %s is optional.\nIf you wish to install it press Y/y.", m.GetID()),
-				"y",
-			) {
-				skipped = append(skipped, m.GetID())
+	started := make(map[ID]struct{})
+	local := modules.Map()
+	done, broken := dma.done, dma.broken
+	outcomes := make(chan *moduleOutcome)
+	running, printed := 0, 0
+	// outputs buffer the command output of the running modules, exclusive is set while an interactive one runs.
+	outputs := make(map[ID]*bytes.Buffer)
+	exclusive := false
+	// mandatoryErr stops scheduling new modules, dependencyErr aborts the run after the stage is done.
+	var mandatoryErr, dependencyErr error
+
+	for {
+		for _, m := range modules {
+			if mandatoryErr != nil || ctx.Err() != nil || running >= dma.jobs || exclusive {
+				break
+			}
+			if _, ok := started[m.GetID()]; ok {
 				continue
 			}
+
+			key := Qualify(stage, m.GetID())
+			ready, brokenDep := dependenciesDone(stage, m, local, done, broken)
+			if brokenDep != "" {
+				started[m.GetID()], done[key], broken[key] = struct{}{}, struct{}{}, struct{}{}
+				printed++
+				fmt.Printf(fmtSkipDep, printed, total, m.GetID(), brokenDep)
+				dma.record(report, m, &ModuleReport{
+					ID:      m.GetID(),
+					Stage:   stage,
+					Status:  StatusDependencyFailed,
+					Meta:    fmt.Sprintf("dependency '%s' failed", brokenDep),
+					Started: time.Now(),
+				})
+				continue
+			}
+			if !ready {
+				continue
+			}
+			// An interactive module waits for the running ones, nothing else starts in the meantime.
+			interactive := dma.jobs > 1 && isInteractive(m)
+			if interactive && running > 0 {
+				break
+			}
+
+			started[m.GetID()] = struct{}{}
+			if when, ok := dma.unmet[key]; ok {
+				done[key] = struct{}{}
+				printed++
+				fmt.Printf(fmtSkipUnmet, printed, total, m.GetID(), when)
+				dma.record(report, m, &ModuleReport{
+					ID:      m.GetID(),
+					Stage:   stage,
+					Status:  StatusConditionUnmet,
+					Meta:    "when: " + when,
+					Started: time.Now(),
+				})
+				continue
+			}
+
+			if dma.resumer != nil && dma.resumer.Succeeded(m) {
+				done[key] = struct{}{}
+				printed++
+				fmt.Printf(fmtSkipResume, printed, total, m.GetID())
+				dma.record(report, m, &ModuleReport{
+					ID:      m.GetID(),
+					Stage:   stage,
+					Status:  StatusSkipped,
+					Meta:    "succeeded in the resumed run",
+					Started: time.Now(),
+				})
+				continue
+			}
+
+			if _, ok := dma.declined[key]; ok {
+				done[key] = struct{}{}
+				printed++
+				fmt.Printf(fmtSkipDecline, printed, total, m.GetID())
+				dma.record(report, m, &ModuleReport{
+					ID:      m.GetID(),
+					Stage:   stage,
+					Status:  StatusDeclined,
+					Started: time.Now(),
+				})
+				continue
+			}
+
+			applyCtx := moduleCtx
+			if interactive {
+				exclusive = true
+			} else if dma.jobs > 1 {
+				outputs[m.GetID()] = new(bytes.Buffer)
+				applyCtx = WithOutput(moduleCtx, outputs[m.GetID()])
+			}
+			running++
+			go applyWithRetries(ctx, applyCtx, m, dma.policy(s, m), outcomes)
 		}
-		ok, meta, err := m.Apply(ctx)
-		if err != nil {
-			fmt.Printf(fmtErrorApply, i+1, total, m.GetID(), meta, err.Error())
-			log.Debug("error applying module", "module", m, "err", err)
+
+		if running == 0 {
+			break
+		}
+
+		outcome := <-outcomes
+		m := outcome.module
+		if !outcome.final {
+			fmt.Printf(fmtRetry, m.GetID(), outcome.attempt, outcome.retries+1, outcome.retryIn, outcome.err.Error())
+			log.Debug("retrying module", "module", m, "attempt", outcome.attempt, "err", outcome.err)
+			continue
+		}
+		running--
+		printed++
+		exclusive = false
+		if output, ok := outputs[m.GetID()]; ok {
+			delete(outputs, m.GetID())
+			printOutput(output)
+		}
+		key := Qualify(stage, m.GetID())
+		done[key] = struct{}{}
+		moduleReport := &ModuleReport{
+			ID:       m.GetID(),
+			Stage:    stage,
+			Meta:     outcome.meta,
+			Started:  outcome.started,
+			Duration: outcome.duration,
+			Attempts: outcome.attempts,
+		}
+
+		switch {
+		case outcome.interrupted:
+			fmt.Printf(fmtInterrupted, printed, total, m.GetID(), outcome.meta)
+			moduleReport.Status, moduleReport.Error = StatusInterrupted, outcome.err.Error()
+			broken[key] = struct{}{}
+		case outcome.err != nil:
+			moduleReport.Status, moduleReport.Error = StatusFailed, outcome.err.Error()
+			if outcome.timedOut {
+				moduleReport.Status = StatusTimedOut
+				fmt.Printf(fmtTimedOut, printed, total, m.GetID(), dma.policy(s, m).timeout, outcome.meta)
+			} else {
+				fmt.Printf(fmtErrorApply, printed, total, m.GetID(), outcome.meta, outcome.err.Error())
+			}
+			if len(outcome.attempts) > 1 {
+				fmt.Printf(fmtGaveUp, m.GetID(), len(outcome.attempts))
+			}
+			log.Debug("error applying module", "module", m, "err", outcome.err)
+			broken[key] = struct{}{}
 
 			if m.IsDependency() {
 				fmt.Printf(fmtFailedDep, m.GetDependants())
-				os.Exit(1)
+				if dependencyErr == nil {
+					dependencyErr = &ErrDependencyFailed{
+						Stage: stage, Module: m.GetID(), Dependants: m.GetDependants(), Err: outcome.err,
+					}
+				}
 			}
 			if m.IsMandatory() {
 				fmt.Printf(fmtFailedMandatory)
-				os.Exit(1)
+				if mandatoryErr == nil {
+					mandatoryErr = &ErrMandatoryFailed{Stage: stage, Module: m.GetID(), Err: outcome.err}
+				}
 			}
-			failed[m.GetID()] = err
-			continue
-		}
-		if !ok {
-			fmt.Printf(fmtSkip, i+1, total, m.GetID(), meta)
-			skipped = append(skipped, m.GetID())
-			continue
+		case !outcome.ok:
+			fmt.Printf(fmtSkip, printed, total, m.GetID(), outcome.meta)
+			moduleReport.Status = StatusSkipped
+		default:
+			moduleReport.Status = StatusApplied
+			fmt.Printf(fmtSuccess, printed, total, m.GetID(), outcome.meta)
 		}
+		dma.record(report, m, moduleReport)
+	}
+
+	report.Duration = time.Since(report.Started)
+	dma.printResults(report, total)
 
-		applied = append(applied, m.GetID())
-		fmt.Printf(fmtSuccess, i+1, total, m.GetID(), meta)
+	if err := ctx.Err(); err != nil {
+		color.Red("[!] interrupted, %d of %d modules weren't started\n", total-len(report.Modules), total)
+		return report, &ErrInterrupted{Stage: stage, Err: err}
+	}
+	if mandatoryErr != nil {
+		return report, mandatoryErr
 	}
+	if dependencyErr != nil {
+		return report, dependencyErr
+	}
+	return report, nil
+}
 
-	dma.printResults(stage, len(applied), len(skipped), len(failed), total)
+// record adds the module report to the stage report and passes it to the recorders.
+// Recording is best effort, a failing recorder doesn't fail the module.
+func (dma *stagesApplier) record(report *StageReport, m Module, moduleReport *ModuleReport) {
+	moduleReport.Hash = dma.hashes[Qualify(report.ID, m.GetID())]
+	report.Modules = append(report.Modules, moduleReport)
+	for _, r := range dma.recorders {
+		if err := r.Record(m, moduleReport); err != nil {
+			color.Yellow("[warn] couldn't record module '%s': %s", m.GetID(), err)
+		}
+	}
+}
 
-	return []bool{}, nil
+// dependenciesDone reports whether all dependencies of the module are done, the ones in the same stage
+// and the qualified ones in the stages before. If any of them is broken its ID is returned.
+func dependenciesDone(stage ID, m Module, local map[ID]Module, done, broken map[ID]struct{}) (bool, ID) {
+	ready := true
+	for _, d := range m.GetDependencies() {
+		key := d
+		if _, ok := local[d]; ok {
+			key = Qualify(stage, d)
+		}
+		if _, ok := broken[key]; ok {
+			return false, d
+		}
+		if _, ok := done[key]; !ok {
+			ready = false
+		}
+	}
+	return ready, ""
 }
 
-func (dma *stagesApplier) printResults(stage ID, applied, skipped, failed, total int) {
+func (dma *stagesApplier) printResults(report *StageReport, total int) {
+	applied, failed, skipped := report.Count(StatusApplied), 0, 0
+	for _, m := range report.Modules {
+		switch {
+		case m.Status.Skipped():
+			skipped++
+		case m.Status.Failed():
+			failed++
+		}
+	}
+
 	color.White("\n")
 	// color.White("summary: ")
 	if applied > 0 {
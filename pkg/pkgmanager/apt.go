@@ -0,0 +1,173 @@
+package pkgmanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/tenderly/furnish/pkg/module/modules/shell"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+)
+
+const TypeApt ManagerName = "apt"
+
+// aptOptions keep apt from asking questions, dpkg keeps the local version of changed config files.
+// apt waits for the dpkg lock held by other processes, e.g. unattended upgrades, instead of failing.
+const aptOptions = "-y -q -o DPkg::Lock::Timeout=120 -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold"
+
+// dpkgLock serializes the apt commands of furnish, dpkg allows one of them at a time on the machine.
+var dpkgLock sync.Mutex
+
+var _ ManagerInfo = (*debianAptInfo)(nil)
+
+type debianAptInfo struct {
+	path      string
+	queryPath string
+	prefix    string
+}
+
+// HowToInstall is empty since apt comes with the distribution and can't be installed by furnish.
+func (*debianAptInfo) HowToInstall() string { return "" }
+
+func (*debianAptInfo) Name() ManagerName { return TypeApt }
+
+func (dai *debianAptInfo) Cmd() string {
+	return strings.TrimSpace(fmt.Sprintf("%s DEBIAN_FRONTEND=noninteractive %s", dai.prefix, dai.path))
+}
+
+func (dai *debianAptInfo) Path() string { return dai.path }
+
+func (dai *debianAptInfo) BinaryExists() bool { return shell.BinaryExists(dai.Path()) }
+
+// debianAptDefaults resolves the binaries from PATH. Root doesn't need a prefix,
+// everyone else runs apt through non interactive sudo after the credentials are validated.
+func debianAptDefaults() *debianAptInfo {
+	prefix := ""
+	if os.Geteuid() != 0 {
+		prefix = "sudo -n"
+	}
+	return &debianAptInfo{
+		path:      "apt-get",
+		queryPath: "dpkg-query",
+		prefix:    prefix,
+	}
+}
+
+type AptPackageManager struct {
+	info *debianAptInfo
+	// refreshed is set once the package index is updated, it's guarded by the dpkg lock.
+	refreshed bool
+}
+
+func NewAptPackageManager(cfg *Config) (Manager, error) {
+	defaults := debianAptDefaults()
+	info := &debianAptInfo{
+		path:      cfg.Path,
+		queryPath: defaults.queryPath,
+		prefix:    cfg.prefix(defaults.prefix),
+	}
+
+	if strings.HasPrefix(info.prefix, "sudo") && !shell.BinaryExists("sudo") {
+		return nil, errors.New("apt needs to run as root or with sudo, sudo not found")
+	}
+
+	color.HiBlue("[init] apt initialized, using cmd: %s", info.Cmd())
+	return &AptPackageManager{info: info}, nil
+}
+
+// Escalate validates the sudo credentials up front, which caches them so the package commands don't prompt.
+func (a *AptPackageManager) Escalate(ctx context.Context) error {
+	if !strings.HasPrefix(a.info.prefix, "sudo") {
+		return nil
+	}
+	if err := shell.Exec(ctx, "sudo -v"); err != nil {
+		return errors.Wrap(err, "couldn't validate sudo credentials")
+	}
+	return nil
+}
+
+func (a *AptPackageManager) Cmd() string { return a.info.Cmd() }
+
+func (a *AptPackageManager) Name() ManagerName { return a.info.Name() }
+
+func (a *AptPackageManager) Path() string { return a.info.Path() }
+
+func (a *AptPackageManager) BinaryExists() bool { return a.info.BinaryExists() }
+
+func (a *AptPackageManager) HowToInstall() string { return a.info.HowToInstall() }
+
+func (a *AptPackageManager) Install(ctx context.Context, pkg *Package) error {
+	dpkgLock.Lock()
+	defer dpkgLock.Unlock()
+
+	a.refreshIndex(ctx)
+	if output, err := shell.ExecCombinedOutput(ctx, fmt.Sprintf("%s install %s %s", a.Cmd(), aptOptions, pkg.Name)); err != nil {
+		return errors.Wrapf(err, "couldn't install package: %s", aptErrors(output))
+	}
+	return nil
+}
+
+func (a *AptPackageManager) Exists(ctx context.Context, pkg *Package) (bool, error) {
+	output, err := shell.ExecOutput(ctx, fmt.Sprintf("%s -W -f='${Status}' %s", a.info.queryPath, pkg.Name))
+	if err != nil {
+		return false, nil
+	}
+	return strings.Contains(output, "install ok installed"), nil
+}
+
+func (a *AptPackageManager) Update(ctx context.Context, pkg *Package) error {
+	dpkgLock.Lock()
+	defer dpkgLock.Unlock()
+
+	a.refreshIndex(ctx)
+	command := fmt.Sprintf("%s install --only-upgrade %s %s", a.Cmd(), aptOptions, pkg.Name)
+	if output, err := shell.ExecCombinedOutput(ctx, command); err != nil {
+		return errors.Wrapf(err, "couldn't update package: %s", aptErrors(output))
+	}
+	return nil
+}
+
+func (a *AptPackageManager) Delete(ctx context.Context, pkg *Package) error {
+	dpkgLock.Lock()
+	defer dpkgLock.Unlock()
+
+	if output, err := shell.ExecCombinedOutput(ctx, fmt.Sprintf("%s remove %s %s", a.Cmd(), aptOptions, pkg.Name)); err != nil {
+		return errors.Wrapf(err, "couldn't uninstall package: %s", aptErrors(output))
+	}
+	return nil
+}
+
+// refreshIndex updates the package index once per run, fresh machines and CI runners start with an empty one.
+// A failed update is only warned about, unless the module was cancelled, then the next module tries again.
+// The dpkg lock must be held.
+func (a *AptPackageManager) refreshIndex(ctx context.Context) {
+	if a.refreshed {
+		return
+	}
+	if output, err := shell.ExecCombinedOutput(ctx, fmt.Sprintf("%s update -q", a.Cmd())); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		color.Yellow("[warn] couldn't update the apt package index: %s", aptErrors(output))
+	}
+	a.refreshed = true
+}
+
+// aptErrors returns the error lines of the apt output, or its last line if apt didn't print any.
+func aptErrors(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	errs := make([]string, 0, 1)
+	for _, line := range lines {
+		if strings.HasPrefix(line, "E: ") {
+			errs = append(errs, strings.TrimPrefix(line, "E: "))
+		}
+	}
+	if len(errs) == 0 {
+		return lines[len(lines)-1]
+	}
+	return strings.Join(errs, "; ")
+}
@@ -0,0 +1,190 @@
+package pkgmanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tenderly/furnish/pkg/module"
+	"github.com/tenderly/furnish/pkg/module/modules/shell"
+)
+
+// fakeAptGet records the calls and fails like apt when it runs while another call holds the lock.
+const fakeAptGet = `#!/bin/sh
+echo "$1" >> "$FAKE_APT_DIR/calls"
+mkdir "$FAKE_APT_DIR/lock" 2>/dev/null || { echo "E: Could not get lock /var/lib/dpkg/lock-frontend" >&2; exit 100; }
+trap 'rmdir "$FAKE_APT_DIR/lock"' EXIT
+sleep 0.05
+eval pkg=\${$#}
+if [ "$pkg" = missing ]; then
+	echo "Reading package lists..."
+	echo "E: Unable to locate package missing" >&2
+	exit 100
+fi
+case "$1" in
+install)
+	echo "$pkg" >> "$FAKE_APT_DIR/installed" ;;
+remove)
+	grep -vx "$pkg" "$FAKE_APT_DIR/installed" > "$FAKE_APT_DIR/left"
+	mv "$FAKE_APT_DIR/left" "$FAKE_APT_DIR/installed" ;;
+esac
+`
+
+const fakeDpkgQuery = `#!/bin/sh
+if grep -qx "$3" "$FAKE_APT_DIR/installed" 2>/dev/null; then
+	printf 'install ok installed'
+else
+	echo "dpkg-query: no packages found matching $3" >&2
+	exit 1
+fi
+`
+
+// fakeApt puts apt-get and dpkg-query recording their calls in a temporary directory on PATH.
+func fakeApt(t *testing.T) (Manager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, script := range map[string]string{"apt-get": fakeAptGet, "dpkg-query": fakeDpkgQuery} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_APT_DIR", dir)
+
+	noPrefix := ""
+	m, err := NewAptPackageManager(&Config{Name: TypeApt, Path: "apt-get", Prefix: &noPrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, dir
+}
+
+func calls(t *testing.T, dir, command string) int {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == command {
+			count++
+		}
+	}
+	return count
+}
+
+func TestAptPackageManager(t *testing.T) {
+	ctx := context.Background()
+	m, dir := fakeApt(t)
+	git := &Package{Name: "git"}
+
+	if exists, err := m.Exists(ctx, git); err != nil || exists {
+		t.Fatalf("git exists before installing: %v, %v", exists, err)
+	}
+	if err := m.Install(ctx, git); err != nil {
+		t.Fatalf("install: %s", err)
+	}
+	if exists, err := m.Exists(ctx, git); err != nil || !exists {
+		t.Fatalf("git doesn't exist after installing: %v, %v", exists, err)
+	}
+	if err := m.Update(ctx, git); err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if err := m.Delete(ctx, git); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if exists, err := m.Exists(ctx, git); err != nil || exists {
+		t.Fatalf("git exists after deleting: %v, %v", exists, err)
+	}
+	if n := calls(t, dir, "update"); n != 1 {
+		t.Errorf("refreshed the index %d times, want once", n)
+	}
+}
+
+func TestAptPackageManagerErrors(t *testing.T) {
+	m, _ := fakeApt(t)
+	missing := &Package{Name: "missing"}
+
+	for name, apply := range map[string]ApplyFunc{"install": m.Install, "update": m.Update, "delete": m.Delete} {
+		err := apply(context.Background(), missing)
+		if err == nil {
+			t.Errorf("%s of a missing package succeeded", name)
+			continue
+		}
+		if !strings.Contains(err.Error(), "Unable to locate package missing") {
+			t.Errorf("%s: got error %q, want the apt error", name, err)
+		}
+	}
+}
+
+func TestAptPackageManagerRefreshAfterCancel(t *testing.T) {
+	m, dir := fakeApt(t)
+	git := &Package{Name: "git"}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Install(cancelled, git); err == nil {
+		t.Fatal("install with a cancelled context succeeded")
+	}
+	if err := m.Install(context.Background(), git); err != nil {
+		t.Fatalf("install: %s", err)
+	}
+	if n := calls(t, dir, "update"); n != 1 {
+		t.Errorf("refreshed the index %d times, want once after the cancelled install", n)
+	}
+}
+
+func TestAptPackageManagerParallel(t *testing.T) {
+	m, _ := fakeApt(t)
+	names := []module.ID{"git", "curl", "jq", "make", "tmux", "zsh"}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name module.ID) {
+			defer wg.Done()
+			errs[i] = m.Install(context.Background(), &Package{Name: name})
+		}(i, name)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("install %s: %s", names[i], err)
+		}
+	}
+}
+
+func TestNewAptPackageManagerPrefix(t *testing.T) {
+	none, doas := "", "doas"
+	tests := []struct {
+		name   string
+		prefix *string
+		want   string
+	}{
+		{name: "unset", prefix: nil, want: debianAptDefaults().prefix},
+		{name: "empty", prefix: &none, want: ""},
+		{name: "declared", prefix: &doas, want: "doas"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strings.HasPrefix(tt.want, "sudo") && !shell.BinaryExists("sudo") {
+				t.Skip("sudo not found")
+			}
+			m, err := NewAptPackageManager(&Config{Name: TypeApt, Path: "apt-get", Prefix: tt.prefix})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.TrimSpace(tt.want + " DEBIAN_FRONTEND=noninteractive apt-get")
+			if m.Cmd() != want {
+				t.Errorf("got cmd %q, want %q", m.Cmd(), want)
+			}
+		})
+	}
+}
@@ -3,12 +3,14 @@ package pkgmanager
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/tenderly/furnish/pkg/module/modules/shell"
 
 	"github.com/fatih/color"
-	"github.com/klauspost/cpuid/v2"
 	"github.com/pkg/errors"
+
+	"github.com/tenderly/furnish/pkg/facts"
 )
 
 const TypeBrew ManagerName = "brew"
@@ -25,20 +27,24 @@ func (mbi *macOSBrewInfo) HowToInstall() string { return mbi.installCommand }
 
 func (*macOSBrewInfo) Name() ManagerName { return TypeBrew }
 
-func (mbi *macOSBrewInfo) Cmd() string { return fmt.Sprintf("%s %s", mbi.prefix, TypeBrew) }
+func (mbi *macOSBrewInfo) Cmd() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", mbi.prefix, TypeBrew))
+}
 
 func (mbi *macOSBrewInfo) Path() string { return mbi.path }
 
 func (mbi *macOSBrewInfo) BinaryExists() bool { return shell.BinaryExists(mbi.Path()) }
 
-var macOSBrewDefaults = newMacOSBrewDefaults()
-
-func newMacOSBrewDefaults() *macOSBrewInfo {
-	path := "/usr/local/bin/brew"
-	prefix := "arch -x86_64"
-	if cpuid.CPU.VendorID != cpuid.Intel && cpuid.CPU.VendorID != cpuid.AMD {
-		path = "/opt/homebrew/bin/brew"
-		prefix = "arch -arm64"
+// macOSBrewDefaults picks the brew installation of the machine architecture. On macOS brew is run
+// through arch, so an Intel build of furnish running under Rosetta still uses the arm64 brew.
+// It's called when a brew config is validated, so commands which don't need brew don't gather the facts.
+func macOSBrewDefaults() *macOSBrewInfo {
+	path, prefix := "/home/linuxbrew/.linuxbrew/bin/brew", ""
+	if machine, _ := facts.Current(); machine != nil && machine.OS == "darwin" {
+		path, prefix = "/usr/local/bin/brew", "arch -x86_64"
+		if machine.Arch == "arm64" {
+			path, prefix = "/opt/homebrew/bin/brew", "arch -arm64"
+		}
 	}
 	return &macOSBrewInfo{
 		path:           path,
@@ -52,13 +58,11 @@ type BrewPackageManager struct {
 }
 
 func NewBrewPackageManager(cfg *Config) (Manager, error) {
-	if cfg.Prefix == "" {
-		cfg.Prefix = macOSBrewDefaults.prefix
-	}
+	defaults := macOSBrewDefaults()
 	info := &macOSBrewInfo{
 		path:           cfg.Path,
-		prefix:         cfg.Prefix,
-		installCommand: macOSBrewDefaults.installCommand,
+		prefix:         cfg.prefix(defaults.prefix),
+		installCommand: defaults.installCommand,
 	}
 
 	color.HiBlue("[init] brew initialized, using cmd: %s", info.Cmd())
@@ -74,28 +78,28 @@ func (b *BrewPackageManager) Path() string { return b.info.Path() }
 func (b *BrewPackageManager) BinaryExists() bool { return b.info.BinaryExists() }
 
 func (b *BrewPackageManager) Install(ctx context.Context, pkg *Package) error {
-	if err := shell.ExecSilent(fmt.Sprintf("%s install %s", b.Cmd(), pkg.Name)); err != nil {
+	if err := shell.ExecSilent(ctx, fmt.Sprintf("%s install %s", b.Cmd(), pkg.Name)); err != nil {
 		return errors.New("package doesn't exist")
 	}
 	return nil
 }
 
 func (b *BrewPackageManager) Exists(ctx context.Context, pkg *Package) (bool, error) {
-	if err := shell.ExecSilent(fmt.Sprintf("%s list %s", b.Cmd(), pkg.Name)); err != nil {
+	if err := shell.ExecSilent(ctx, fmt.Sprintf("%s list %s", b.Cmd(), pkg.Name)); err != nil {
 		return false, nil
 	}
 	return true, nil
 }
 
 func (b *BrewPackageManager) Update(ctx context.Context, pkg *Package) error {
-	if err := shell.ExecSilent(fmt.Sprintf("%s upgrade %s", b.Cmd(), pkg.Name)); err != nil {
+	if err := shell.ExecSilent(ctx, fmt.Sprintf("%s upgrade %s", b.Cmd(), pkg.Name)); err != nil {
 		return errors.New("couldn't update package")
 	}
 	return nil
 }
 
 func (b *BrewPackageManager) Delete(ctx context.Context, pkg *Package) error {
-	if err := shell.Exec(fmt.Sprintf("%s uninstall %s", b.Cmd(), pkg.Name)); err != nil {
+	if err := shell.Exec(ctx, fmt.Sprintf("%s uninstall %s", b.Cmd(), pkg.Name)); err != nil {
 		return errors.New("couldn't uninstall package")
 	}
 	return nil
@@ -1,16 +1,21 @@
 package pkgmanager
 
 import (
+	"context"
 	"errors"
+	"fmt"
 
 	"github.com/fatih/color"
 
+	"github.com/tenderly/furnish/pkg/facts"
 	"github.com/tenderly/furnish/pkg/module/modules/shell"
 	"github.com/tenderly/furnish/pkg/util"
 )
 
-var supprotedPackageManagers = map[ManagerName]Defaults{
-	TypeBrew: macOSBrewDefaults,
+// supprotedPackageManagers return the defaults of the managers, they are worked out when they're needed.
+var supprotedPackageManagers = map[ManagerName]func() Defaults{
+	TypeBrew: func() Defaults { return macOSBrewDefaults() },
+	TypeApt:  func() Defaults { return debianAptDefaults() },
 }
 
 type Config struct {
@@ -18,18 +23,49 @@ type Config struct {
 	Path    string      `yaml:"path"    json:"path"`
 	Default bool        `yaml:"default" json:"default"`
 
-	// Prefix is a specific macos field
-	Prefix string `yaml:"prefix" json:"prefix"`
+	// Prefix is prepended to the manager command, unset uses arch for brew on macos and sudo for apt.
+	// An empty prefix runs the manager as it is.
+	Prefix *string `yaml:"prefix" json:"prefix,omitempty"`
 }
 
+// prefix returns the declared prefix or the default one if it isn't set.
+func (c *Config) prefix(defaults string) string {
+	if c.Prefix == nil {
+		return defaults
+	}
+	return *c.Prefix
+}
+
+var errManagerNotFound = errors.New("package manager doesn't exist, or it's the wrong path")
+
+// Validate checks the manager like Check, and offers to install a missing manager which can be installed.
 func (c *Config) Validate() error {
+	err := c.Check()
+	if !errors.Is(err, errManagerNotFound) {
+		return err
+	}
+	defaults := supprotedPackageManagers[c.Name]()
+	if defaults.HowToInstall() != "" &&
+		util.Confirm(
+			fmt.Sprintf("install-%s", c.Name),
+			fmt.Sprintf("%s not found but we can install it.\nIf you wish to install %s press Y/y.", c.Name, c.Name),
+		) {
+		return shell.Exec(context.Background(), defaults.HowToInstall())
+	}
+	color.Red("package manager %s not found, or it's the wrong path", c.Name)
+	return err
+}
+
+// Check resolves the path of the manager, falling back to the default one, without changing the machine.
+func (c *Config) Check() error {
 	if c.Name == "" {
 		return errors.New("no name for package manager")
 	}
-	defaults, ok := supprotedPackageManagers[c.Name]
+	newDefaults, ok := supprotedPackageManagers[c.Name]
 	if !ok {
 		return errors.New("unsupported package manager")
 	}
+	defaults := newDefaults()
 	if c.Path == "" {
 		c.Path = defaults.Path()
 	}
@@ -41,15 +77,7 @@ func (c *Config) Validate() error {
 			c.Path = defaults.Path()
 			return nil
 		}
-		if defaults.HowToInstall() != "" &&
-			util.ReadConfirmation(
-				"Brew not found but we can install it.\nIf you wish to install brew press Y/y.",
-				"y",
-			) {
-			return shell.Exec(defaults.HowToInstall())
-		}
-		color.Red("package manager %s not found, or it's the wrong path", c.Name)
-		return errors.New("package manager doesn't exist, or it's the wrong path")
+		return errManagerNotFound
 	}
 
 	return nil
@@ -57,19 +85,72 @@ func (c *Config) Validate() error {
 
 type MultiManagerConfig []*Config
 
+// Validate checks the managers and picks the default one, if none is marked as the default the first
+// manager found on the machine is.
 func (mmc MultiManagerConfig) Validate() error {
+	return mmc.validate((*Config).Validate)
+}
+
+// Check is Validate without installing the missing managers.
+func (mmc MultiManagerConfig) Check() error {
+	return mmc.validate((*Config).Check)
+}
+
+func (mmc MultiManagerConfig) validate(validate func(*Config) error) error {
 	if len(mmc) == 0 {
 		return errors.New("no manager provided, need to provide atleast 1 manager as the default")
 	}
-	if len(mmc) == 1 {
-		mmc[0].Default = true
+	if !mmc.hasDefault() {
+		mmc.pickDefault().Default = true
 	}
 	for _, c := range mmc {
-		if err := c.Validate(); err != nil {
+		if err := validate(c); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func (mmc MultiManagerConfig) hasDefault() bool {
+	for _, c := range mmc {
+		if c.Default {
+			return true
+		}
+	}
+	return false
+}
+
+func (mmc MultiManagerConfig) pickDefault() *Config {
+	if machine, err := facts.Current(); err == nil {
+		for _, c := range mmc {
+			if machine.HasPackageManager(string(c.Name)) {
+				return c
+			}
+		}
+	}
+	return mmc[0]
+}
+
+// Detect returns the config of the supported manager found on the machine, used when the declaration
+// doesn't list any. The native manager of the distribution is preferred over brew on linux.
+func Detect() (*Config, error) {
+	machine, err := facts.Current()
+	if err != nil {
+		return nil, err
+	}
+	preferred := []ManagerName{TypeBrew, TypeApt}
+	if machine.OS == "linux" {
+		preferred = []ManagerName{TypeApt, TypeBrew}
+	}
+	for _, name := range preferred {
+		if machine.HasPackageManager(string(name)) {
+			return &Config{Name: name, Default: true}, nil
+		}
+	}
+	return nil, errors.New("no supported package manager found on the machine")
+}
+
 func (mmc MultiManagerConfig) Initialize() error { return ConfigureManagers(mmc) }
+
+// InitializeChecked configures the checked managers for plan, it doesn't validate the sudo credentials.
+func (mmc MultiManagerConfig) InitializeChecked() error { return configureManagers(mmc, false) }
@@ -67,15 +67,30 @@ func (mp *managerProvider) register(m Manager, def bool) {
 	}
 }
 
+// escalator is a manager which needs elevated privileges to change the machine.
+type escalator interface {
+	Escalate(ctx context.Context) error
+}
+
 func ConfigureManagers(mmc MultiManagerConfig) error {
 	if err := mmc.Validate(); err != nil {
 		return err
 	}
+	return configureManagers(mmc, true)
+}
+
+// configureManagers registers the validated managers, escalating the ones which need it if asked to.
+func configureManagers(mmc MultiManagerConfig, escalate bool) error {
 	for _, cfg := range mmc {
 		m, err := configureManager(cfg)
 		if err != nil {
 			return errors.Wrap(err, "couldn't configure manager")
 		}
+		if e, ok := m.(escalator); ok && escalate {
+			if err := e.Escalate(context.Background()); err != nil {
+				return errors.Wrapf(err, "couldn't configure manager %s", cfg.Name)
+			}
+		}
 		globalManagerProvider.register(m, cfg.Default)
 	}
 
@@ -83,10 +98,14 @@ func ConfigureManagers(mmc MultiManagerConfig) error {
 }
 
 func configureManager(cfg *Config) (Manager, error) {
-	if cfg.Name == TypeBrew {
+	switch cfg.Name {
+	case TypeBrew:
 		return NewBrewPackageManager(cfg)
+	case TypeApt:
+		return NewAptPackageManager(cfg)
+	default:
+		return nil, errors.New("package manager not supported")
 	}
-	return nil, errors.New("package manager not supported")
 }
 
 func ProvideManager(name ManagerName) (Manager, error) { return globalManagerProvider.Provide(name) }
@@ -5,10 +5,22 @@ import (
 	"fmt"
 
 	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 
 	"github.com/tenderly/furnish/pkg/module"
 )
 
+const Kind module.Kind = "packages"
+
+func init() { module.Register(Kind, decode) }
+
+func decode(node *yaml.Node) (module.Modules, error) {
+	return module.DecodeSequence(node, func(item *yaml.Node) (module.Module, error) {
+		p := &Package{}
+		return p, item.Decode(p)
+	})
+}
+
 type assertExists func(ctx context.Context) (bool, error)
 
 type pkgApplier string
@@ -20,7 +32,11 @@ const (
 	pkgApplierEmpty   = ""
 )
 
-var _ module.Module = (*Package)(nil)
+var (
+	_ module.Module    = (*Package)(nil)
+	_ module.Applier   = (*Package)(nil)
+	_ module.Validator = (*Package)(nil)
+)
 
 type Package struct {
 	module.BaseDependable `yaml:",inline"`
@@ -39,8 +55,30 @@ func (p *Package) GetID() module.ID { return p.Name }
 
 func (p *Package) IsMandatory() bool { return p.Mandatory }
 
+func (p *Package) GetApplier() string {
+	if p.Applier == pkgApplierEmpty {
+		return pkgApplierInstall
+	}
+	return string(p.Applier)
+}
+
 func (p *Package) IsOptional() bool { return p.Optional }
 
+func (p *Package) Validate() error {
+	if p.Name == "" {
+		return errors.New("package must have a name")
+	}
+	switch p.Applier {
+	case pkgApplierInstall, pkgApplierUpdate, pkgApplierDelete, pkgApplierEmpty:
+		return nil
+	default:
+		return errors.Errorf(
+			"unknown applier '%s', expected %s, %s or %s",
+			p.Applier, pkgApplierInstall, pkgApplierUpdate, pkgApplierDelete,
+		)
+	}
+}
+
 func (p *Package) Apply(ctx context.Context) (bool, string, error) {
 	m, err := p.manager()
 	if err != nil {
@@ -60,12 +98,41 @@ func (p *Package) Apply(ctx context.Context) (bool, string, error) {
 	}
 }
 
+func (p *Package) Check(ctx context.Context) (module.Action, string, error) {
+	m, err := p.manager()
+	if err != nil {
+		return module.ActionSkip, "", err
+	}
+	p.setMeta(m)
+
+	exists, err := m.Exists(ctx, p)
+	if err != nil {
+		return module.ActionSkip, p.meta, errors.Wrap(err, "couldn't check if package exists")
+	}
+
+	switch p.GetApplier() {
+	case pkgApplierInstall:
+		if !exists {
+			return module.ActionInstall, p.meta, nil
+		}
+	case pkgApplierUpdate:
+		if exists {
+			return module.ActionUpdate, p.meta, nil
+		}
+	case pkgApplierDelete:
+		if exists {
+			return module.ActionDelete, p.meta, nil
+		}
+	}
+	return module.ActionSkip, p.meta, nil
+}
+
 func (p *Package) apply(ctx context.Context, apply ApplyFunc, exists assertExists) (bool, string, error) {
 	if ok, err := exists(ctx); err != nil || !ok {
 		return ok, p.meta, err
 	}
 	if err := apply(ctx, p); err != nil {
-		return false, p.meta, errors.Wrap(err, fmt.Sprintf("couldn't %s package", p.Applier))
+		return false, p.meta, errors.Wrap(err, fmt.Sprintf("couldn't %s package", p.GetApplier()))
 	}
 	return true, p.meta, nil
 }
@@ -99,10 +166,7 @@ func (p *Package) manager() (Manager, error) {
 }
 
 func (p *Package) setMeta(m Manager) {
-	if p.Applier == pkgApplierEmpty {
-		p.Applier = pkgApplierInstall
-	}
-	p.meta = fmt.Sprintf("applier: %s; manager: %s", p.Applier, m.Name())
+	p.meta = fmt.Sprintf("applier: %s; manager: %s", p.GetApplier(), m.Name())
 }
 
 type Packages []*Package
@@ -0,0 +1,210 @@
+package furnish
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+
+	"github.com/tenderly/furnish/pkg/module"
+)
+
+// Profile selects a subset of the declaration by stage names, module IDs and tags.
+// Module IDs can be qualified with the stage, stage/module. Profiles stack through extends.
+type Profile struct {
+	Extends []string `yaml:"extends" json:"extends,omitempty"`
+	Stages  []string `yaml:"stages"  json:"stages,omitempty"`
+	Modules []string `yaml:"modules" json:"modules,omitempty"`
+	Tags    []string `yaml:"tags"    json:"tags,omitempty"`
+}
+
+type Profiles map[string]*Profile
+
+// selection is the union of the stacked profiles.
+type selection struct {
+	stages  map[string]struct{}
+	modules map[string]struct{}
+	tags    []string
+}
+
+func (ps Profiles) resolve(names []string) (*selection, error) {
+	sel := &selection{stages: make(map[string]struct{}), modules: make(map[string]struct{})}
+	resolved := make(map[string]struct{})
+
+	var add func(name string, path []string) error
+	add = func(name string, path []string) error {
+		for _, p := range path {
+			if p == name {
+				return errors.Errorf("profile cycle: %s -> %s", strings.Join(path, " -> "), name)
+			}
+		}
+		if _, ok := resolved[name]; ok {
+			return nil
+		}
+		profile, ok := ps[name]
+		if !ok || profile == nil {
+			return errors.Errorf("profile '%s' not found", name)
+		}
+		resolved[name] = struct{}{}
+
+		for _, s := range profile.Stages {
+			sel.stages[s] = struct{}{}
+		}
+		for _, m := range profile.Modules {
+			sel.modules[m] = struct{}{}
+		}
+		sel.tags = append(sel.tags, profile.Tags...)
+
+		for _, parent := range profile.Extends {
+			if err := add(parent, append(path, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, name := range names {
+		if err := add(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// SelectProfiles keeps only the stages and modules selected by the profiles, and the modules they depend on.
+// Without names the profiles in use-profiles are selected, without either the declaration is kept whole.
+func (d *Declaration) SelectProfiles(names ...string) error {
+	if len(names) == 0 {
+		names = d.UseProfiles
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	sel, err := d.Profiles.resolve(names)
+	if err != nil {
+		return err
+	}
+	if err := d.checkSelection(sel); err != nil {
+		return err
+	}
+
+	keep := d.selectModules(sel)
+	selected := make(Stages, len(d.Phases))
+	for id, stage := range d.Phases {
+		if stage == nil {
+			continue
+		}
+		if _, ok := sel.stages[id]; ok || stage.HasTag(sel.tags...) {
+			selected[id] = stage
+			continue
+		}
+
+		modules := make(module.Modules, 0, len(stage.Declared))
+		for _, m := range stage.Declared {
+			if _, ok := keep[module.Qualify(module.ID(id), m.GetID())]; ok {
+				modules = append(modules, m)
+			}
+		}
+		if len(modules) == 0 {
+			continue
+		}
+		stage.Declared = modules
+		selected[id] = stage
+	}
+
+	// Stage dependencies only order the stages, the ones on stages which weren't selected are dropped.
+	for _, stage := range selected {
+		dependencies := make(module.IDs, 0, len(stage.Dependencies))
+		for _, dep := range stage.Dependencies {
+			if _, ok := selected[dep.String()]; ok {
+				dependencies = append(dependencies, dep)
+			}
+		}
+		stage.Dependencies = dependencies
+	}
+
+	d.Phases = selected
+	color.HiBlue("[init] selected profiles %s: %d stages", strings.Join(names, ", "), len(selected))
+	return nil
+}
+
+// selectModules returns the qualified IDs of the selected modules together with their dependencies,
+// also the ones in other stages.
+func (d *Declaration) selectModules(sel *selection) map[module.ID]struct{} {
+	keep := make(map[module.ID]struct{})
+
+	var add func(stage module.ID, m module.Module)
+	add = func(stage module.ID, m module.Module) {
+		key := module.Qualify(stage, m.GetID())
+		if _, ok := keep[key]; ok {
+			return
+		}
+		keep[key] = struct{}{}
+
+		local := d.Phases[stage.String()].Declared.Map()
+		for _, dep := range m.GetDependencies() {
+			if dependency, ok := local[dep]; ok {
+				add(stage, dependency)
+				continue
+			}
+			depStage, depID, ok := dep.Split()
+			if !ok || d.Phases[depStage.String()] == nil {
+				continue
+			}
+			if dependency, ok := d.Phases[depStage.String()].Declared.Map()[depID]; ok {
+				add(depStage, dependency)
+			}
+		}
+	}
+
+	for id, stage := range d.Phases {
+		if stage == nil {
+			continue
+		}
+		_, wholeStage := sel.stages[id]
+		wholeStage = wholeStage || stage.HasTag(sel.tags...)
+		for _, m := range stage.Declared {
+			_, byID := sel.modules[m.GetID().String()]
+			_, byQualifiedID := sel.modules[module.Qualify(module.ID(id), m.GetID()).String()]
+			if wholeStage || byID || byQualifiedID || m.HasTag(sel.tags...) {
+				add(module.ID(id), m)
+			}
+		}
+	}
+	return keep
+}
+
+// checkSelection fails on stages and modules which the profiles select but aren't declared,
+// they are most likely misspelled.
+func (d *Declaration) checkSelection(sel *selection) error {
+	missing := make([]string, 0)
+	for s := range sel.stages {
+		if _, ok := d.Phases[s]; !ok {
+			missing = append(missing, "stage '"+s+"'")
+		}
+	}
+
+	declared := make(map[string]struct{})
+	for id, stage := range d.Phases {
+		if stage == nil {
+			continue
+		}
+		for _, m := range stage.Declared {
+			declared[m.GetID().String()] = struct{}{}
+			declared[module.Qualify(module.ID(id), m.GetID()).String()] = struct{}{}
+		}
+	}
+	for m := range sel.modules {
+		if _, ok := declared[m]; !ok {
+			missing = append(missing, "module '"+m+"'")
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return errors.Errorf("profiles select undeclared %s", strings.Join(missing, ", "))
+}
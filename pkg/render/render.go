@@ -0,0 +1,129 @@
+package render
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Data is what the templates in the declaration can refer to, e.g. {{ .Vars.email }}, {{ .Env.HOME }}
+// and {{ .Facts.OS }}.
+type Data struct {
+	Vars  map[string]string
+	Env   map[string]string
+	Facts interface{}
+}
+
+// NewData returns the template data with the current environment.
+func NewData(vars map[string]string, facts interface{}) *Data {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+	return &Data{Vars: vars, Env: env, Facts: facts}
+}
+
+var funcs = template.FuncMap{
+	// env returns the variable or an empty string, unlike .Env which fails on unset variables.
+	"env": os.Getenv,
+}
+
+// String renders the template, strings without actions are returned as they are.
+// Referring to an undefined variable fails.
+func String(text string, data *Data) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Strings renders every exported string field of the value in place, walking through pointers,
+// interfaces, structs, slices and maps. Fields tagged `render:"-"` are left as they are.
+// The value must be a pointer.
+func Strings(v interface{}, data *Data) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr {
+		return errors.Errorf("render: expected a pointer, got %s", value.Kind())
+	}
+	return walk(value, data, "")
+}
+
+func walk(v reflect.Value, data *Data, path string) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return walk(v.Elem(), data, path)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() || field.Tag.Get("render") == "-" {
+				continue
+			}
+			// Embedded structs, e.g. the base of the modules, share the path of the outer struct.
+			name := path
+			if !field.Anonymous {
+				name = join(path, strings.ToLower(field.Name))
+			}
+			if err := walk(v.Field(i), data, name); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := walk(v.Index(i), data, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			key, value := iter.Key(), iter.Value()
+			keyPath := join(path, fmt.Sprint(key.Interface()))
+			if value.Kind() != reflect.String {
+				if err := walk(value, data, keyPath); err != nil {
+					return err
+				}
+				continue
+			}
+			rendered, err := String(value.String(), data)
+			if err != nil {
+				return errors.Wrap(err, keyPath)
+			}
+			v.SetMapIndex(key, reflect.ValueOf(rendered).Convert(value.Type()))
+		}
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		rendered, err := String(v.String(), data)
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+		v.SetString(rendered)
+	}
+	return nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
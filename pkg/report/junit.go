@@ -0,0 +1,117 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tenderly/furnish/pkg/module"
+)
+
+// The JUnit layout maps every stage to a test suite and every module to a test case. An error failing
+// the run as a whole, e.g. a dependency cycle, is an errored test case of its own suite.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr,omitempty"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func writeJUnit(w io.Writer, r *module.RunReport) error {
+	suites := junitSuites{Name: "furnish", Time: seconds(r.Duration)}
+	for _, s := range r.Stages {
+		suite := junitSuite{Name: s.ID.String(), Time: seconds(s.Duration)}
+		// The stages the run stopped before have no start.
+		if !s.Started.IsZero() {
+			suite.Timestamp = s.Started.Format(time.RFC3339)
+		}
+		for _, m := range s.Modules {
+			testCase := junitCase{
+				Name:      m.ID.String(),
+				Classname: s.ID.String(),
+				Time:      seconds(m.Duration),
+				SystemOut: m.Meta,
+			}
+			for _, a := range m.Attempts {
+				if a.Error != "" {
+					testCase.SystemOut += fmt.Sprintf("\nattempt %d failed: %s", a.Attempt, a.Error)
+				}
+			}
+			switch {
+			case m.Status.Failed():
+				testCase.Failure = &junitMessage{Message: m.Error, Body: m.Error}
+				suite.Failures++
+			case m.Status.Skipped():
+				testCase.Skipped = &junitMessage{Message: string(m.Status)}
+				suite.Skipped++
+			}
+			suite.Cases = append(suite.Cases, testCase)
+			suite.Tests++
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+	if r.Error != "" {
+		suites.Suites = append(suites.Suites, junitSuite{
+			Name:      "furnish",
+			Tests:     1,
+			Errors:    1,
+			Time:      seconds(r.Duration),
+			Timestamp: r.Started.Format(time.RFC3339),
+			Cases: []junitCase{{
+				Name:      "run",
+				Classname: "furnish",
+				Time:      seconds(r.Duration),
+				Error:     &junitMessage{Message: r.Error, Body: r.Error},
+			}},
+		})
+		suites.Tests++
+		suites.Errors++
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string { return fmt.Sprintf("%.3f", d.Seconds()) }
@@ -0,0 +1,58 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/tenderly/furnish/pkg/module"
+)
+
+type Format string
+
+const (
+	FormatJSON  Format = "json"
+	FormatJUnit Format = "junit"
+)
+
+func (f Format) Validate() error {
+	switch f {
+	case FormatJSON, FormatJUnit:
+		return nil
+	default:
+		return errors.Errorf("unsupported report format '%s', use %s or %s", f, FormatJSON, FormatJUnit)
+	}
+}
+
+// Write encodes the run report in the format.
+func Write(w io.Writer, format Format, r *module.RunReport) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case FormatJUnit:
+		return writeJUnit(w, r)
+	default:
+		return format.Validate()
+	}
+}
+
+// WriteFile writes the run report to the path, or to stdout if the path is empty.
+func WriteFile(path string, format Format, r *module.RunReport) error {
+	if path == "" {
+		return Write(os.Stdout, format, r)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "create report file")
+	}
+	if err := Write(file, format, r); err != nil {
+		file.Close()
+		return errors.Wrap(err, "write report")
+	}
+	return file.Close()
+}
@@ -0,0 +1,123 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/tenderly/furnish/pkg/module"
+)
+
+var (
+	_ module.Recorder = (*Journal)(nil)
+	_ module.Resumer  = (*Journal)(nil)
+)
+
+// JournalEntry is the outcome of a module in the journaled run.
+type JournalEntry struct {
+	ID     module.ID           `json:"id"`
+	Stage  module.ID           `json:"stage"`
+	Status module.ModuleStatus `json:"status"`
+	Hash   string              `json:"hash"`
+}
+
+// succeeded reports whether the module doesn't need to run again, declined modules count as well
+// so resuming doesn't ask for the optional modules again.
+func (je *JournalEntry) succeeded() bool {
+	return je.Status == module.StatusApplied || je.Status == module.StatusSkipped ||
+		je.Status == module.StatusDeclined
+}
+
+// Journal records the outcome of every module during a run, so a failed run can be resumed.
+type Journal struct {
+	path string
+	mu   sync.Mutex
+
+	Started time.Time                `json:"started"`
+	Entries map[string]*JournalEntry `json:"entries"`
+
+	// previous contains the entries of the resumed run.
+	previous map[string]*JournalEntry
+}
+
+// JournalPath returns the path of the journal of the config, next to the state file. Every config has
+// a journal of its own, so resuming one doesn't skip modules based on a run of another.
+func JournalPath(statePath, configPath string) (string, error) {
+	abs, err := filepath.Abs(configPath)
+	if err != nil {
+		return "", errors.Wrap(err, "config path")
+	}
+	sum := sha256.Sum256([]byte(abs))
+	name := "journal-" + hex.EncodeToString(sum[:8]) + ".json"
+	return filepath.Join(filepath.Dir(statePath), "journals", name), nil
+}
+
+// NewJournal starts a new journal at the path. When resuming, the entries of the previous run are
+// carried over, otherwise the previous journal is discarded.
+func NewJournal(path string, resume bool) (*Journal, error) {
+	j := &Journal{
+		path:     path,
+		Started:  time.Now(),
+		Entries:  make(map[string]*JournalEntry),
+		previous: make(map[string]*JournalEntry),
+	}
+
+	if resume {
+		previous := &Journal{}
+		if err := readJSON(path, previous); err != nil {
+			return nil, errors.Wrap(err, "read journal")
+		}
+		for key, entry := range previous.Entries {
+			j.previous[key] = entry
+			j.Entries[key] = entry
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, errors.Wrap(err, "create journal directory")
+	}
+	return j, j.write()
+}
+
+// Resuming reports whether there is a previous run to resume.
+func (j *Journal) Resuming() bool { return len(j.previous) > 0 }
+
+// Succeeded reports whether the module succeeded in the resumed run with the same configuration.
+func (j *Journal) Succeeded(m module.Module) bool {
+	entry, ok := j.previous[Key(m.GetParent(), m.GetID())]
+	if !ok {
+		return false
+	}
+	return entry.succeeded() && entry.Hash == module.Hash(m)
+}
+
+// Record adds the module outcome to the journal and writes it.
+func (j *Journal) Record(m module.Module, r *module.ModuleReport) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Entries[Key(r.Stage, r.ID)] = &JournalEntry{
+		ID:     r.ID,
+		Stage:  r.Stage,
+		Status: r.Status,
+		Hash:   reportHash(m, r),
+	}
+	return j.write()
+}
+
+// reportHash returns the hash taken before the module was applied, applying may change the module.
+func reportHash(m module.Module, r *module.ModuleReport) string {
+	if r.Hash != "" {
+		return r.Hash
+	}
+	return module.Hash(m)
+}
+
+func (j *Journal) write() error {
+	return writeJSON(j.path, j)
+}
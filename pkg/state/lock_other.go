@@ -0,0 +1,6 @@
+//go:build !unix
+
+package state
+
+// lockFile is a no-op where flock isn't available, furnish only provisions unix machines.
+func lockFile(path string) (func(), error) { return func() {}, nil }
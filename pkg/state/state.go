@@ -0,0 +1,101 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/tenderly/furnish/pkg/module"
+)
+
+const stateVersion = 1
+
+// Entry records a module applied by furnish.
+type Entry struct {
+	ID        module.ID      `json:"id"`
+	Stage     module.ID      `json:"stage"`
+	Kind      module.Kind    `json:"kind"`
+	Applier   string         `json:"applier,omitempty"`
+	Version   module.Version `json:"version,omitempty"`
+	Hash      string         `json:"hash"`
+	AppliedAt time.Time      `json:"applied_at"`
+}
+
+// State contains every module furnish has applied, keyed by Key.
+type State struct {
+	Version int               `json:"version"`
+	Modules map[string]*Entry `json:"modules"`
+}
+
+func newState() *State {
+	return &State{Version: stateVersion, Modules: make(map[string]*Entry)}
+}
+
+// Key identifies a module across stages.
+func Key(stage, id module.ID) string { return stage.String() + "/" + id.String() }
+
+// Dir returns the directory furnish keeps its state in, $XDG_STATE_HOME/furnish or ~/.local/state/furnish.
+func Dir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "furnish"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't find home directory")
+	}
+	return filepath.Join(home, ".local", "state", "furnish"), nil
+}
+
+// DefaultPath returns the path of the state file in the state directory.
+func DefaultPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "state.json"), nil
+}
+
+// readJSON decodes the file into v, a missing file leaves v untouched.
+func readJSON(path string, v interface{}) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "read")
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return errors.Wrapf(err, "decode %s", path)
+	}
+	return nil
+}
+
+// writeJSON atomically replaces the file by writing to a temporary file in the same directory and renaming it.
+func writeJSON(path string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write temporary file")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "sync temporary file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "close temporary file")
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,91 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/tenderly/furnish/pkg/module"
+)
+
+var _ module.Recorder = (*Store)(nil)
+
+// Store persists the state to a file. Every update holds a file lock for the whole
+// read-modify-write cycle, so concurrent furnish runs don't lose each others records.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewStore(path string) *Store { return &Store{path: path} }
+
+func (s *Store) Path() string { return s.path }
+
+// Load reads the state, a missing state file is an empty state.
+func (s *Store) Load() (*State, error) {
+	st := newState()
+	if err := readJSON(s.path, st); err != nil {
+		return nil, err
+	}
+	if st.Modules == nil {
+		st.Modules = make(map[string]*Entry)
+	}
+	return st, nil
+}
+
+// Update applies the change to the current state and writes it back.
+func (s *Store) Update(change func(*State) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return errors.Wrap(err, "create state directory")
+	}
+
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return errors.Wrap(err, "lock state")
+	}
+	defer unlock()
+
+	st, err := s.Load()
+	if err != nil {
+		return err
+	}
+	if err := change(st); err != nil {
+		return err
+	}
+	return writeJSON(s.path, st)
+}
+
+// Record stores the applied modules. Modules which were deleted are removed from the state,
+// anything which wasn't applied leaves the state as it is.
+func (s *Store) Record(m module.Module, r *module.ModuleReport) error {
+	if r.Status != module.StatusApplied {
+		return nil
+	}
+
+	entry := &Entry{
+		ID:        m.GetID(),
+		Stage:     r.Stage,
+		Kind:      m.GetKind(),
+		Version:   m.GetVersion(),
+		Hash:      reportHash(m, r),
+		AppliedAt: time.Now(),
+	}
+	if applier, ok := m.(module.Applier); ok {
+		entry.Applier = applier.GetApplier()
+	}
+
+	return s.Update(func(st *State) error {
+		if entry.Applier == "delete" {
+			delete(st.Modules, Key(entry.Stage, entry.ID))
+			return nil
+		}
+		st.Modules[Key(entry.Stage, entry.ID)] = entry
+		return nil
+	})
+}
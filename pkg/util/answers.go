@@ -0,0 +1,98 @@
+package util
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var _ Prompter = (*AnswersPrompter)(nil)
+
+// AnswersPrompter answers the questions from a map of keys to answers.
+// Questions without an answer are passed to the fallback prompter.
+type AnswersPrompter struct {
+	answers  map[string]bool
+	fallback Prompter
+}
+
+func NewAnswersPrompter(answers map[string]bool, fallback Prompter) *AnswersPrompter {
+	return &AnswersPrompter{answers: answers, fallback: fallback}
+}
+
+func (ap *AnswersPrompter) Confirm(key, question string) (bool, error) {
+	if answer, ok := ap.answers[key]; ok {
+		return answer, nil
+	}
+	if ap.fallback == nil {
+		return false, errors.Errorf("no answer for '%s'", key)
+	}
+	return ap.fallback.Confirm(key, question)
+}
+
+// Select picks the options by their key or name, the options without an answer are passed to the fallback.
+func (ap *AnswersPrompter) Select(question string, options []Option) ([]string, error) {
+	selected := make([]string, 0, len(options))
+	unanswered := make([]Option, 0, len(options))
+	for _, o := range options {
+		answer, ok := ap.answers[o.Key]
+		if !ok {
+			answer, ok = ap.answers[o.Name]
+		}
+		if !ok {
+			unanswered = append(unanswered, o)
+			continue
+		}
+		if answer {
+			selected = append(selected, o.Key)
+		}
+	}
+
+	if len(unanswered) == 0 {
+		return selected, nil
+	}
+	if ap.fallback == nil {
+		return selected, errors.Errorf("no answers for %d options", len(unanswered))
+	}
+	rest, err := ap.fallback.Select(question, unanswered)
+	if err != nil {
+		return selected, err
+	}
+	return append(selected, rest...), nil
+}
+
+// LoadAnswers reads a yaml file mapping keys to yes or no.
+func LoadAnswers(path string) (map[string]bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "answers file read")
+	}
+
+	raw := make(map[string]string)
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, errors.Wrap(err, "unmarshal answers")
+	}
+
+	answers := make(map[string]bool, len(raw))
+	for key, value := range raw {
+		answer, err := ParseAnswer(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "answer for '%s'", key)
+		}
+		answers[key] = answer
+	}
+	return answers, nil
+}
+
+// ParseAnswer parses y, yes, true, n, no and false regardless of case.
+func ParseAnswer(answer string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes", "true":
+		return true, nil
+	case "n", "no", "false":
+		return false, nil
+	default:
+		return false, errors.Errorf("invalid answer '%s', use yes or no", answer)
+	}
+}
@@ -2,19 +2,156 @@ package util
 
 import (
 	"bufio"
+	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/fatih/color"
+	"github.com/pkg/errors"
 )
 
-var reader = bufio.NewReader(os.Stdin)
+// Prompter asks for confirmations. The key identifies what is being confirmed, e.g. a module ID,
+// so the answers can also be given up front.
+type Prompter interface {
+	Confirm(key, question string) (bool, error)
+	// Select asks to pick any number of the options and returns the keys of the picked ones.
+	Select(question string, options []Option) ([]string, error)
+}
+
+// Option is a choice in a multi-select. Name is the short key the answers can also use.
+type Option struct {
+	Key         string
+	Name        string
+	Description string
+}
+
+var global Prompter = NewReaderPrompter(os.Stdin)
+
+// SetPrompter sets the global prompter, unsafe to call while a run is in progress.
+func SetPrompter(p Prompter) {
+	global = p
+}
+
+// Confirm asks the global prompter. A question which can't be answered, e.g. because stdin is closed,
+// counts as declined.
+func Confirm(key, question string) bool {
+	ok, err := global.Confirm(key, question)
+	if err != nil {
+		color.Yellow("[warn] no answer for '%s', declining: %s", key, err)
+		return false
+	}
+	return ok
+}
 
-func ReadConfirmation(print, confirm string) bool {
-	color.Yellow(print)
-	input, err := reader.ReadString('\n')
+// Select asks the global prompter. The options which can't be picked, e.g. because stdin is closed,
+// aren't selected.
+func Select(question string, options []Option) []string {
+	selected, err := global.Select(question, options)
 	if err != nil {
-		panic(err)
+		color.Yellow("[warn] not all options answered, the rest aren't selected: %s", err)
+	}
+	return selected
+}
+
+var _ Prompter = (*ReaderPrompter)(nil)
+
+// ReaderPrompter prints the question and reads the answer line from the reader, y and yes confirm.
+type ReaderPrompter struct {
+	reader *bufio.Reader
+}
+
+func NewReaderPrompter(r io.Reader) *ReaderPrompter {
+	return &ReaderPrompter{reader: bufio.NewReader(r)}
+}
+
+func (rp *ReaderPrompter) Confirm(key, question string) (bool, error) {
+	color.Yellow(question)
+	input, err := rp.reader.ReadString('\n')
+	if err != nil && (err != io.EOF || input == "") {
+		return false, err
+	}
+	// Anything that isn't a clear yes declines, same as pressing enter.
+	answer, _ := ParseAnswer(input)
+	return answer, nil
+}
+
+// Select prints a numbered checklist and reads the numbers of the picked options separated by commas
+// or spaces, all picks every option and an empty line or none picks nothing.
+func (rp *ReaderPrompter) Select(question string, options []Option) ([]string, error) {
+	color.Yellow(question)
+	for i, o := range options {
+		line := fmt.Sprintf("  [%d] %s", i+1, o.Key)
+		if o.Description != "" {
+			line += color.WhiteString(" - %s", o.Description)
+		}
+		fmt.Println(line)
+	}
+
+	for {
+		color.Yellow("Enter the numbers to apply, all or none:")
+		input, err := rp.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || input == "") {
+			return nil, err
+		}
+
+		selected, err := parseSelection(input, options)
+		if err == nil {
+			return selected, nil
+		}
+		color.Red(err.Error())
+	}
+}
+
+func parseSelection(input string, options []Option) ([]string, error) {
+	input = strings.ToLower(strings.TrimSpace(input))
+	switch input {
+	case "", "none":
+		return nil, nil
+	case "all":
+		return optionKeys(options), nil
+	}
+
+	selected := make([]string, 0, len(options))
+	for _, field := range strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' }) {
+		i, err := strconv.Atoi(field)
+		if err != nil || i < 1 || i > len(options) {
+			return nil, errors.Errorf("invalid selection '%s', pick numbers between 1 and %d", field, len(options))
+		}
+		selected = append(selected, options[i-1].Key)
+	}
+	return selected, nil
+}
+
+func optionKeys(options []Option) []string {
+	keys := make([]string, 0, len(options))
+	for _, o := range options {
+		keys = append(keys, o.Key)
+	}
+	return keys
+}
+
+var _ Prompter = StaticPrompter(false)
+
+// StaticPrompter gives the same answer to every question, used for --yes and --no.
+type StaticPrompter bool
+
+func (sp StaticPrompter) Confirm(key, question string) (bool, error) {
+	color.Yellow(question)
+	if sp {
+		color.Yellow("answered yes")
+	} else {
+		color.Yellow("answered no")
+	}
+	return bool(sp), nil
+}
+
+func (sp StaticPrompter) Select(question string, options []Option) ([]string, error) {
+	if sp {
+		color.Yellow("%s\nselected all %d options", question, len(options))
+		return optionKeys(options), nil
 	}
-	return strings.TrimSpace(input) == strings.ToLower(confirm)
+	color.Yellow("%s\nselected none of the %d options", question, len(options))
+	return nil, nil
 }
@@ -0,0 +1,229 @@
+package furnish
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/tenderly/furnish/pkg/condition"
+	"github.com/tenderly/furnish/pkg/module"
+	"github.com/tenderly/furnish/pkg/pkgmanager"
+)
+
+// Problem is something wrong with the declaration, found without touching the machine.
+type Problem struct {
+	Source  module.Source `json:"source"`
+	Message string        `json:"message"`
+
+	// fatal problems stop the declaration from being applied, the others are only reported by validate.
+	fatal bool
+}
+
+func (p Problem) String() string {
+	if src := p.Source.String(); src != "" {
+		return src + ": " + p.Message
+	}
+	return p.Message
+}
+
+type Problems []Problem
+
+func (ps Problems) sort() {
+	sort.SliceStable(ps, func(i, j int) bool {
+		if ps[i].Source.File != ps[j].Source.File {
+			return ps[i].Source.File < ps[j].Source.File
+		}
+		return ps[i].Source.Line < ps[j].Source.Line
+	})
+}
+
+// Check finds every problem of the declaration instead of stopping at the first one: unknown keys,
+// missing dependencies, dependency cycles, duplicate module IDs, invalid conditions and modules
+// which fail their own validation. Unlike Validate it doesn't check the package managers,
+// so nothing is installed or asked.
+func (d *Declaration) Check() Problems {
+	problems := append(Problems{}, d.problems...)
+
+	stageIDs := make([]string, 0, len(d.Phases))
+	for id, s := range d.Phases {
+		if s != nil {
+			stageIDs = append(stageIDs, id)
+		}
+	}
+	sort.Strings(stageIDs)
+
+	stageModules := make(map[module.ID]map[module.ID]module.Module, len(stageIDs))
+	for _, id := range stageIDs {
+		stageModules[module.ID(id)] = d.Phases[id].Declared.Map()
+	}
+
+	// stages contain the declared and the implied stage dependencies, to find the cycles between them.
+	stages := make(module.Dependables, 0, len(stageIDs))
+	// declared and declaredIn contain the first declaration of every unqualified module ID and its stage,
+	// to report the IDs repeated across stages.
+	declared := make(map[module.ID]module.Module)
+	declaredIn := make(map[module.ID]string)
+	for _, id := range stageIDs {
+		s := d.Phases[id]
+		stage := &module.BaseDependable{ID: module.ID(id), Dependencies: append(module.IDs{}, s.Dependencies...)}
+		stages = append(stages, stage)
+		problems = append(problems, s.problems...)
+		problems = append(problems, checkDependable(s, fmt.Sprintf("stage '%s'", id))...)
+
+		for _, dep := range s.Dependencies {
+			if _, ok := d.Phases[dep.String()]; !ok {
+				problems = append(problems, Problem{
+					Source:  s.Source,
+					Message: fmt.Sprintf("stage '%s' depends on stage '%s' which isn't declared", id, dep),
+				})
+			}
+		}
+
+		modules := make(module.Dependables, 0, len(s.Declared))
+		inStage := make(map[module.ID]module.Module, len(s.Declared))
+		for _, m := range s.Declared {
+			modules = append(modules, m)
+			what := fmt.Sprintf("module '%s'", m.GetID())
+			problems = append(problems, checkDependable(m, what)...)
+
+			existing, inOtherStage := declared[m.GetID()]
+			if existingInStage, ok := inStage[m.GetID()]; ok {
+				problems = append(problems, Problem{
+					Source:  m.GetSource(),
+					Message: fmt.Sprintf("%s is already declared at %s", what, existingInStage.GetSource()),
+				})
+				continue
+			}
+			inStage[m.GetID()] = m
+			if inOtherStage {
+				problems = append(problems, Problem{
+					Source: m.GetSource(),
+					Message: fmt.Sprintf(
+						"%s is also declared in stage '%s' at %s", what, declaredIn[m.GetID()], existing.GetSource(),
+					),
+				})
+				continue
+			}
+			declared[m.GetID()], declaredIn[m.GetID()] = m, id
+		}
+
+		for _, m := range s.Declared {
+			for _, dep := range m.GetDependencies() {
+				if _, ok := inStage[dep]; ok {
+					continue
+				}
+				depStage, depID, qualified := dep.Split()
+				_, found := stageModules[depStage][depID]
+				switch {
+				case qualified && found && depStage == module.ID(id):
+					problems = append(problems, Problem{
+						Source:  m.GetSource(),
+						Message: fmt.Sprintf("module '%s' refers to '%s' of its own stage, use '%s'", m.GetID(), dep, depID),
+					})
+				case qualified && found:
+					if _, ok := stage.Dependencies.Unique()[depStage]; !ok {
+						stage.Dependencies = append(stage.Dependencies, depStage)
+					}
+				default:
+					problems = append(problems, Problem{
+						Source:  m.GetSource(),
+						Message: fmt.Sprintf("module '%s' depends on '%s' which isn't declared in stage '%s'", m.GetID(), dep, id),
+					})
+				}
+			}
+		}
+		for _, cycle := range modules.Cycles() {
+			problems = append(problems, Problem{
+				Source:  cycleSource(s.Declared.Map(), cycle, s.Source),
+				Message: (&module.ErrDependencyCycle{Stage: module.ID(id), Path: cycle}).Error(),
+			})
+		}
+	}
+	for _, cycle := range stages.Cycles() {
+		problems = append(problems, Problem{
+			Source:  d.Phases[cycle[0].String()].Source,
+			Message: (&module.ErrDependencyCycle{Path: cycle}).Error(),
+		})
+	}
+
+	problems.sort()
+	return problems
+}
+
+// cycleSource points at the first module of the cycle.
+func cycleSource(modules map[module.ID]module.Module, cycle module.IDs, fallback module.Source) module.Source {
+	if m, ok := modules[cycle[0]]; ok {
+		return m.GetSource()
+	}
+	return fallback
+}
+
+// checkDependable checks the condition and the module's own validation.
+func checkDependable(d module.Dependable, what string) Problems {
+	problems := make(Problems, 0)
+	if when := d.GetWhen(); when != "" {
+		if _, err := condition.Parse(when); err != nil {
+			problems = append(problems, Problem{Source: d.GetSource(), Message: fmt.Sprintf("%s: %s", what, err)})
+		}
+	}
+	if retries, ok := d.GetRetries(); ok && retries < 0 {
+		problems = append(problems, Problem{
+			Source: d.GetSource(), Message: fmt.Sprintf("%s: retries can't be negative", what),
+		})
+	}
+	if delay, ok := d.GetRetryDelay(); ok && delay < 0 {
+		problems = append(problems, Problem{
+			Source: d.GetSource(), Message: fmt.Sprintf("%s: retry-delay can't be negative", what),
+		})
+	}
+	if timeout, ok := d.GetTimeout(); ok && timeout < 0 {
+		problems = append(problems, Problem{
+			Source: d.GetSource(), Message: fmt.Sprintf("%s: timeout can't be negative", what),
+		})
+	}
+	if v, ok := d.(module.Validator); ok {
+		if err := v.Validate(); err != nil {
+			problems = append(problems, Problem{Source: d.GetSource(), Message: fmt.Sprintf("%s: %s", what, err)})
+		}
+	}
+	return problems
+}
+
+// checkKeys records the unknown keys outside of the stages, in global and the profiles.
+func (d *Declaration) checkKeys(path string, root *yaml.Node) {
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return
+	}
+
+	unknown := func(node *yaml.Node, v interface{}, where string) {
+		for _, key := range module.UnknownKeys(node, v) {
+			d.problems = append(d.problems, Problem{
+				Source:  module.Source{File: path, Line: key.Line},
+				Message: fmt.Sprintf("unknown key '%s' in %s", key.Value, where),
+			})
+		}
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		switch key {
+		case "global":
+			unknown(value, Global{}, "global")
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				if value.Content[j].Value == "package-managers" && value.Content[j+1].Kind == yaml.SequenceNode {
+					for _, item := range value.Content[j+1].Content {
+						unknown(item, pkgmanager.Config{}, "package manager")
+					}
+				}
+			}
+		case "profiles":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				unknown(value.Content[j+1], Profile{}, fmt.Sprintf("profile '%s'", value.Content[j].Value))
+			}
+		}
+	}
+}